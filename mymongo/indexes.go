@@ -0,0 +1,160 @@
+package mymongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateIndexes creates the indexes the server's queries rely on
+func CreateIndexes(ctx context.Context) error {
+	database := client.Database("seng468-a2-db")
+
+	// Posts are looked up by their hashtags and mentions, and searched by their content
+	_, err := database.Collection("posts").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "content", Value: "text"}, {Key: "hashtags", Value: "text"}}},
+		{Keys: bson.D{{Key: "hashtags", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "mentions", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Comments are looked up by the post they belong to, by their parent comment and by their hashtags and mentions,
+	// and searched by their content
+	_, err = database.Collection("comments").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "content", Value: "text"}}},
+		{Keys: bson.D{{Key: "post_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "parent_id", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "hashtags", Value: 1}}},
+		{Keys: bson.D{{Key: "mentions", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Revisions are looked up by the post or comment they belong to
+	_, err = database.Collection("revisions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "number", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Each user shares a post at most once, and shares are listed by the post and by the user who shared it
+	_, err = database.Collection("shares").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "post_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "post_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Each user votes once in a poll, and polls are closed by when they are due
+	_, err = database.Collection("poll_votes").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "post_id", Value: 1}, {Key: "username", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = database.Collection("posts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "poll.closed", Value: 1}, {Key: "poll.closes_at", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Each user bookmarks a post once, bookmarks are listed by save time, and collection names are unique per user
+	_, err = database.Collection("bookmarks").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "post_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "collection", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "post_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = database.Collection("bookmark_collections").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "username", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Drafts are listed by their author and scheduled posts are found by when they are due
+	_, err = database.Collection("drafts").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "publish_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "publish_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Users are found by the posts they pinned when those posts are deleted
+	_, err = database.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "pinned_posts", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Conversations are listed by their participants, and two users have at most one direct conversation
+	_, err = database.Collection("conversations").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "members.username", Value: 1}, {Key: "last_message_at", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "direct_key", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"direct_key": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Messages are listed by their conversation, newest first
+	_, err = database.Collection("messages").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "conversation_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// A user has one membership of each group, and group members are listed by role and status
+	_, err = database.Collection("group_members").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "group_id", Value: 1}, {Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "group_id", Value: 1}, {Key: "status", Value: 1}, {Key: "role", Value: -1}}},
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "status", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Group feeds list the posts of a group, newest first
+	_, err = database.Collection("posts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "group_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Stories are listed by their author, and removed by a TTL index once they expire
+	_, err = database.Collection("stories").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// A user views each story once, and the views are removed along with the story they belong to
+	_, err = database.Collection("story_views").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "story_id", Value: 1}, {Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "story_id", Value: 1}, {Key: "viewed_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
@@ -8,47 +8,445 @@ import (
 
 // User represents a user in the database
 type User struct {
-	ID            primitive.ObjectID `bson:"_id,omitempty"`
-	Username      string             `bson:"username"`
-	FirstName     string             `bson:"first_name"`
-	LastName      string             `bson:"last_name"`
-	Email         string             `bson:"email"`
-	Password      string             `bson:"password"`
-	DateOfBirth   time.Time          `bson:"date_of_birth"`
-	ListOfFriends []string           `bson:"list_of_friends"`
-	PostCount     int                `bson:"post_count" json:"post_count"`
-	Notifications []Notification     `bson:"notifications" json:"notifications"`
-	CreatedAt     time.Time          `bson:"created_at" json:"created_at,omitempty"`
-	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at,omitempty"`
+	ID            primitive.ObjectID   `bson:"_id,omitempty"`
+	Username      string               `bson:"username"`
+	FirstName     string               `bson:"first_name"`
+	LastName      string               `bson:"last_name"`
+	Email         string               `bson:"email"`
+	Password      string               `bson:"password"`
+	DateOfBirth   time.Time            `bson:"date_of_birth"`
+	ListOfFriends []string             `bson:"list_of_friends"`
+	BlockedUsers  []string             `bson:"blocked_users,omitempty" json:"blocked_users,omitempty"`
+	Bio           string               `bson:"bio" json:"bio"`
+	Location      string               `bson:"location" json:"location"`
+	Website       string               `bson:"website" json:"website"`
+	Avatar        *Avatar              `bson:"avatar,omitempty" json:"avatar,omitempty"`
+	PinnedPosts   []primitive.ObjectID `bson:"pinned_posts,omitempty" json:"pinned_posts,omitempty"`
+	PostCount     int                  `bson:"post_count" json:"post_count"`
+	Notifications []Notification       `bson:"notifications" json:"notifications"`
+	CreatedAt     time.Time            `bson:"created_at" json:"created_at,omitempty"`
+	UpdatedAt     time.Time            `bson:"updated_at" json:"updated_at,omitempty"`
+	DeletedAt     *time.Time           `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
 }
 
+// Avatar describes a user's avatar image, which is kept in the media store in each of its sizes
+type Avatar struct {
+	Sizes     []string  `bson:"sizes" json:"sizes"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// PublicProfile is the view of a user that anyone may see
+type PublicProfile struct {
+	Username    string    `json:"username"`
+	FirstName   string    `json:"first_name"`
+	LastName    string    `json:"last_name"`
+	Bio         string    `json:"bio"`
+	Location    string    `json:"location"`
+	Website     string    `json:"website"`
+	Avatar      *Avatar   `json:"avatar,omitempty"`
+	FriendCount int       `json:"friend_count"`
+	PostCount   int       `json:"post_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OwnerProfile is the full view of a user that only the user themselves may see, which still leaves out the password
+type OwnerProfile struct {
+	PublicProfile
+	ID            primitive.ObjectID `json:"id"`
+	Email         string             `json:"email"`
+	DateOfBirth   time.Time          `json:"date_of_birth"`
+	ListOfFriends []string           `json:"list_of_friends"`
+	BlockedUsers  []string           `json:"blocked_users"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+}
+
+// PublicProfile returns the view of the user that anyone may see
+func (u *User) PublicProfile() PublicProfile {
+	return PublicProfile{
+		Username:    u.Username,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Bio:         u.Bio,
+		Location:    u.Location,
+		Website:     u.Website,
+		Avatar:      u.Avatar,
+		FriendCount: len(u.ListOfFriends),
+		PostCount:   u.PostCount,
+		CreatedAt:   u.CreatedAt,
+	}
+}
+
+// OwnerProfile returns the full view of the user that only the user themselves may see
+func (u *User) OwnerProfile() OwnerProfile {
+	friends, blocked := u.ListOfFriends, u.BlockedUsers
+	if friends == nil {
+		friends = []string{}
+	}
+	if blocked == nil {
+		blocked = []string{}
+	}
+	return OwnerProfile{
+		PublicProfile: u.PublicProfile(),
+		ID:            u.ID,
+		Email:         u.Email,
+		DateOfBirth:   u.DateOfBirth,
+		ListOfFriends: friends,
+		BlockedUsers:  blocked,
+		UpdatedAt:     u.UpdatedAt,
+	}
+}
+
+// FriendSuggestion is a user someone may know, ranked by the friends they have in common and how much the two have interacted
+type FriendSuggestion struct {
+	Profile           PublicProfile `json:"profile"`
+	MutualFriends     int           `json:"mutual_friends"`
+	MutualFriendNames []string      `json:"mutual_friend_names"`
+	Interactions      int           `json:"interactions"`
+	Score             int           `json:"score"`
+}
+
+// Visibility is who can see a post
+type Visibility string
+
+const (
+	PublicVisibility  Visibility = "public"
+	FriendsVisibility Visibility = "friends"
+	PrivateVisibility Visibility = "private"
+	// GroupVisibility is given to the posts of private groups, which only the group's members can see
+	GroupVisibility Visibility = "group"
+)
+
+// PostStatus is whether a post is published or still being prepared by its author
+type PostStatus string
+
+const (
+	PublishedPost PostStatus = "published"
+	DraftPost     PostStatus = "draft"
+	ScheduledPost PostStatus = "scheduled"
+)
+
+// LatestCommentsLimit is the number of most recent comments previewed on a post
+const LatestCommentsLimit = 3
+
 // Post represents a post in the database
 type Post struct {
-	ID               primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID           primitive.ObjectID `bson:"user_id" json:"user_id"`
-	Username         string             `bson:"username" json:"username"`
-	PostNumber       int                `bson:"post_number" json:"post_number"`
-	Content          string             `bson:"content" json:"content"`
-	CreatedAt        time.Time          `bson:"created_at" json:"created_at,omitempty"`
-	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at,omitempty"`
-	NumberOfLikes    int                `bson:"number_of_likes" json:"number_of_likes"`
-	Likes            []Like             `bson:"likes" json:"likes"`
-	NumberOfComments int                `bson:"number_of_comments" json:"number_of_comments"`
-	Comments         []Comment          `bson:"comments" json:"comments"`
+	ID               primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID           primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	Username         string               `bson:"username" json:"username"`
+	PostNumber       int                  `bson:"post_number" json:"post_number"`
+	Content          string               `bson:"content" json:"content"`
+	Visibility       Visibility           `bson:"visibility,omitempty" json:"visibility"`
+	GroupID          primitive.ObjectID   `bson:"group_id,omitempty" json:"group_id,omitempty"`
+	Status           PostStatus           `bson:"status,omitempty" json:"status,omitempty"`
+	PublishAt        *time.Time           `bson:"publish_at,omitempty" json:"publish_at,omitempty"`
+	CreatedAt        time.Time            `bson:"created_at" json:"created_at,omitempty"`
+	UpdatedAt        time.Time            `bson:"updated_at" json:"updated_at,omitempty"`
+	DeletedAt        *time.Time           `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	NumberOfLikes    int                  `bson:"number_of_likes" json:"number_of_likes"`
+	Likes            []Like               `bson:"likes" json:"likes"`
+	NumberOfComments int                  `bson:"number_of_comments" json:"number_of_comments"`
+	LatestComments   []Comment            `bson:"latest_comments,omitempty" json:"latest_comments"`
+	Edited           bool                 `bson:"edited" json:"edited"`
+	NumberOfEdits    int                  `bson:"number_of_edits" json:"number_of_edits"`
+	Reactions        []Reaction           `bson:"reactions,omitempty" json:"reactions"`
+	ReactionCounts   map[ReactionKind]int `bson:"reaction_counts,omitempty" json:"reaction_counts"`
+	Hashtags         []string             `bson:"hashtags,omitempty" json:"hashtags"`
+	Mentions         []string             `bson:"mentions,omitempty" json:"mentions"`
+	Attachments      []Attachment         `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	NumberOfShares   int                  `bson:"number_of_shares" json:"number_of_shares"`
+	SharedBy         *Share               `bson:"-" json:"shared_by,omitempty"`
+	Poll             *Poll                `bson:"poll,omitempty" json:"poll,omitempty"`
+	Pinned           bool                 `bson:"-" json:"pinned,omitempty"`
 }
 
-// Comment represents a comment in the database
-type Comment struct {
+// Bookmark represents a post a user saved for later, optionally filed in one of their bookmark collections
+type Bookmark struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Username   string             `bson:"username" json:"username"`
+	PostID     primitive.ObjectID `bson:"post_id" json:"post_id"`
+	Collection string             `bson:"collection,omitempty" json:"collection,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	Post       *Post              `bson:"-" json:"post,omitempty"`
+}
+
+// BookmarkCollection represents a named group of a user's bookmarks
+type BookmarkCollection struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Username          string             `bson:"username" json:"username"`
+	Name              string             `bson:"name" json:"name"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	NumberOfBookmarks int64              `bson:"-" json:"number_of_bookmarks"`
+}
+
+// Conversation represents a private conversation between two users, or a small group of users
+type Conversation struct {
+	ID            primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Name          string               `bson:"name,omitempty" json:"name,omitempty"`
+	Group         bool                 `bson:"group" json:"group"`
+	DirectKey     string               `bson:"direct_key,omitempty" json:"-"`
+	Members       []ConversationMember `bson:"members" json:"members"`
+	CreatedBy     string               `bson:"created_by" json:"created_by"`
+	CreatedAt     time.Time            `bson:"created_at" json:"created_at"`
+	LastMessageAt time.Time            `bson:"last_message_at" json:"last_message_at"`
+	UnreadCount   int64                `bson:"-" json:"unread_count"`
+}
+
+// ConversationMember records how far a participant of a conversation has read
+type ConversationMember struct {
+	Username   string    `bson:"username" json:"username"`
+	LastReadAt time.Time `bson:"last_read_at" json:"last_read_at"`
+}
+
+// Story represents a short-lived post that its author's friends can see until it expires
+type Story struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	PostID        primitive.ObjectID `bson:"post_id" json:"post_id"`
-	PostNumber    int                `bson:"post_number" json:"post_number"`
 	UserID        primitive.ObjectID `bson:"user_id" json:"user_id"`
 	Username      string             `bson:"username" json:"username"`
 	Content       string             `bson:"content" json:"content"`
-	CreatedAt     time.Time          `bson:"created_at" json:"created_at,omitempty"`
-	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at,omitempty"`
-	NumberOfLikes int                `bson:"number_of_likes" json:"number_of_likes"`
-	Likes         []Like             `bson:"likes" json:"likes"`
+	NumberOfViews int                `bson:"number_of_views" json:"number_of_views"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt     time.Time          `bson:"expires_at" json:"expires_at"`
+	Viewed        bool               `bson:"-" json:"viewed"`
+}
+
+// StoryView records a user viewing a story, and expires along with it
+type StoryView struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	StoryID   primitive.ObjectID `bson:"story_id" json:"story_id"`
+	Username  string             `bson:"username" json:"username"`
+	ViewedAt  time.Time          `bson:"viewed_at" json:"viewed_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"-"`
+}
+
+// AuthorStories lists the active stories of one author, oldest first, and whether the viewer has seen all of them
+type AuthorStories struct {
+	Username  string  `json:"username"`
+	Stories   []Story `json:"stories"`
+	AllViewed bool    `json:"all_viewed"`
+}
+
+// GroupPrivacy is whether anyone can join a group, or only those a moderator approves
+type GroupPrivacy string
+
+const (
+	PublicGroup  GroupPrivacy = "public"
+	PrivateGroup GroupPrivacy = "private"
+)
+
+// Group represents a community of users who post to it together
+type Group struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name            string             `bson:"name" json:"name"`
+	Description     string             `bson:"description" json:"description"`
+	Privacy         GroupPrivacy       `bson:"privacy" json:"privacy"`
+	Owner           string             `bson:"owner" json:"owner"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+	NumberOfMembers int64              `bson:"-" json:"number_of_members"`
+}
+
+// GroupRole is what a member is allowed to do in a group
+type GroupRole string
+
+const (
+	GroupOwner     GroupRole = "owner"
+	GroupModerator GroupRole = "moderator"
+	GroupMember    GroupRole = "member"
+)
+
+// MembershipStatus is whether a user belongs to a group or is waiting to join it
+type MembershipStatus string
+
+const (
+	ActiveMembership    MembershipStatus = "active"
+	InvitedMembership   MembershipStatus = "invited"
+	RequestedMembership MembershipStatus = "requested"
+)
+
+// GroupMembership represents a user's membership of a group, or their pending invitation or request to join it
+type GroupMembership struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	GroupID   primitive.ObjectID `bson:"group_id" json:"group_id"`
+	Username  string             `bson:"username" json:"username"`
+	Role      GroupRole          `bson:"role" json:"role"`
+	Status    MembershipStatus   `bson:"status" json:"status"`
+	InvitedBy string             `bson:"invited_by,omitempty" json:"invited_by,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	JoinedAt  *time.Time         `bson:"joined_at,omitempty" json:"joined_at,omitempty"`
+}
+
+// Message represents a message sent in a conversation
+type Message struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ConversationID primitive.ObjectID `bson:"conversation_id" json:"conversation_id"`
+	Username       string             `bson:"username" json:"username"`
+	Content        string             `bson:"content" json:"content"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	EditedAt       *time.Time         `bson:"edited_at,omitempty" json:"edited_at,omitempty"`
+	ReadBy         []string           `bson:"-" json:"read_by"`
+}
+
+// PollResultsVisibility is when the results of a poll can be seen by those who did not create it
+type PollResultsVisibility string
+
+const (
+	ResultsAfterVote  PollResultsVisibility = "after_vote"
+	ResultsAfterClose PollResultsVisibility = "after_close"
+)
+
+// Poll represents a poll on a post. Vote counts are only sent through the poll results, which apply the visibility rules.
+type Poll struct {
+	Options           []PollOption          `bson:"options" json:"options"`
+	MultipleChoice    bool                  `bson:"multiple_choice" json:"multiple_choice"`
+	ClosesAt          *time.Time            `bson:"closes_at,omitempty" json:"closes_at,omitempty"`
+	Closed            bool                  `bson:"closed" json:"closed"`
+	ResultsVisibility PollResultsVisibility `bson:"results_visibility" json:"results_visibility"`
+	TotalVoters       int                   `bson:"total_voters" json:"-"`
+}
+
+// PollOption represents one of the options of a poll
+type PollOption struct {
+	ID    int    `bson:"id" json:"id"`
+	Text  string `bson:"text" json:"text"`
+	Votes int    `bson:"votes" json:"-"`
+}
+
+// PollVote represents the options a user voted for in a poll
+type PollVote struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PostID    primitive.ObjectID `bson:"post_id" json:"post_id"`
+	Username  string             `bson:"username" json:"username"`
+	Options   []int              `bson:"options" json:"options"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// PollResults is a poll as seen by a user, with the vote counts only if its results are visible to them
+type PollResults struct {
+	Options        []PollOptionResult `json:"options"`
+	MultipleChoice bool               `json:"multiple_choice"`
+	ClosesAt       *time.Time         `json:"closes_at,omitempty"`
+	Closed         bool               `json:"closed"`
+	ResultsVisible bool               `json:"results_visible"`
+	TotalVoters    *int               `json:"total_voters,omitempty"`
+	Vote           []int              `json:"vote"`
+}
+
+// PollOptionResult is an option of a poll with its vote count, if visible
+type PollOptionResult struct {
+	ID    int    `json:"id"`
+	Text  string `json:"text"`
+	Votes *int   `json:"votes,omitempty"`
+}
+
+// Share represents a user sharing another user's post to their own timeline, with an optional comment
+type Share struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Username   string             `bson:"username" json:"username"`
+	PostID     primitive.ObjectID `bson:"post_id" json:"post_id"`
+	PostAuthor string             `bson:"post_author" json:"post_author"`
+	PostNumber int                `bson:"post_number" json:"post_number"`
+	Comment    string             `bson:"comment,omitempty" json:"comment,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Attachment represents a file attached to a post, whose contents are kept in the media store
+type Attachment struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	Filename     string             `bson:"filename" json:"filename"`
+	ContentType  string             `bson:"content_type" json:"content_type"`
+	Size         int64              `bson:"size" json:"size"`
+	Width        int                `bson:"width,omitempty" json:"width,omitempty"`
+	Height       int                `bson:"height,omitempty" json:"height,omitempty"`
+	HasThumbnail bool               `bson:"has_thumbnail" json:"has_thumbnail"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// Comment represents a comment in the database
+type Comment struct {
+	ID              primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	PostID          primitive.ObjectID   `bson:"post_id" json:"post_id"`
+	PostNumber      int                  `bson:"post_number" json:"post_number"`
+	UserID          primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	Username        string               `bson:"username" json:"username"`
+	Content         string               `bson:"content" json:"content"`
+	CreatedAt       time.Time            `bson:"created_at" json:"created_at,omitempty"`
+	UpdatedAt       time.Time            `bson:"updated_at" json:"updated_at,omitempty"`
+	DeletedAt       *time.Time           `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	NumberOfLikes   int                  `bson:"number_of_likes" json:"number_of_likes"`
+	Likes           []Like               `bson:"likes" json:"likes"`
+	Reactions       []Reaction           `bson:"reactions,omitempty" json:"reactions"`
+	ReactionCounts  map[ReactionKind]int `bson:"reaction_counts,omitempty" json:"reaction_counts"`
+	ParentID        primitive.ObjectID   `bson:"parent_id,omitempty" json:"parent_id,omitempty"`
+	Depth           int                  `bson:"depth" json:"depth"`
+	NumberOfReplies int                  `bson:"number_of_replies" json:"number_of_replies"`
+	Edited          bool                 `bson:"edited" json:"edited"`
+	NumberOfEdits   int                  `bson:"number_of_edits" json:"number_of_edits"`
+	Hashtags        []string             `bson:"hashtags,omitempty" json:"hashtags"`
+	Mentions        []string             `bson:"mentions,omitempty" json:"mentions"`
+}
+
+// CommentThread represents a comment together with a page of its replies
+type CommentThread struct {
+	Comment
+	Replies        []CommentThread `json:"replies"`
+	HasMoreReplies bool            `json:"has_more_replies"`
+}
+
+// RevisionTarget is the kind of document a revision belongs to
+type RevisionTarget string
+
+const (
+	PostRevision    RevisionTarget = "post"
+	CommentRevision RevisionTarget = "comment"
+)
+
+// Revision represents a version of the content of a post or comment.
+// Revision 0 holds the original content, revision n the content after the nth edit.
+type Revision struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TargetType RevisionTarget     `bson:"target_type" json:"target_type"`
+	TargetID   primitive.ObjectID `bson:"target_id" json:"target_id"`
+	Number     int                `bson:"number" json:"number"`
+	Content    string             `bson:"content" json:"content"`
+	Editor     string             `bson:"editor" json:"editor"`
+	EditedAt   time.Time          `bson:"edited_at" json:"edited_at"`
+}
+
+// JobType is the kind of work a background job performs
+type JobType string
+
+const (
+	AccountDeletionJob JobType = "account_deletion"
+	DataExportJob      JobType = "data_export"
+)
+
+// JobStatus is the state of a background job
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job represents a long running background job and its progress
+type Job struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type           JobType            `bson:"type" json:"type"`
+	Username       string             `bson:"username" json:"username"`
+	Status         JobStatus          `bson:"status" json:"status"`
+	Step           string             `bson:"step" json:"step"`
+	StepsCompleted int                `bson:"steps_completed" json:"steps_completed"`
+	TotalSteps     int                `bson:"total_steps" json:"total_steps"`
+	Error          string             `bson:"error,omitempty" json:"error,omitempty"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+	CompletedAt    *time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	ResultKey      string             `bson:"result_key,omitempty" json:"-"`
+	ExpiresAt      *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
 }
 
 type NotificationType string
@@ -58,6 +456,17 @@ const (
 	CommentCreatedNotification NotificationType = "comment_created"
 	PostLikedNotification      NotificationType = "post_liked"
 	CommentLikedNotification   NotificationType = "comment_liked"
+	PostReactedNotification    NotificationType = "post_reacted"
+	CommentReactedNotification NotificationType = "comment_reacted"
+	CommentRepliedNotification NotificationType = "comment_replied"
+	MentionedNotification      NotificationType = "mentioned"
+	PostSharedNotification     NotificationType = "post_shared"
+	PollClosedNotification     NotificationType = "poll_closed"
+	MessageNotification        NotificationType = "message_received"
+	GroupPostNotification      NotificationType = "group_post"
+	GroupInvitedNotification   NotificationType = "group_invited"
+	GroupRequestNotification   NotificationType = "group_join_requested"
+	GroupJoinedNotification    NotificationType = "group_joined"
 )
 
 // Notification represents a notification in the database
@@ -68,6 +477,8 @@ type Notification struct {
 	Type       NotificationType   `bson:"type" json:"type"`
 	PostID     primitive.ObjectID `bson:"post_id" json:"post_id"`
 	CommentID  primitive.ObjectID `bson:"comment_id" json:"comment_id"`
+	MessageID  primitive.ObjectID `bson:"message_id,omitempty" json:"message_id,omitempty"`
+	GroupID    primitive.ObjectID `bson:"group_id,omitempty" json:"group_id,omitempty"`
 	Recipient  string             `bson:"recipient" json:"recipient"`
 	Content    string             `bson:"content" json:"content"`
 	ReadStatus bool               `bson:"read_status" json:"read_status"`
@@ -81,6 +492,34 @@ type Like struct {
 	LikedAt  time.Time          `bson:"liked_at" json:"liked_at"`
 }
 
+// ReactionKind is the kind of reaction a user can leave on a post or comment
+type ReactionKind string
+
+// Reaction represents a user's reaction to a post or comment
+type Reaction struct {
+	Username  string       `bson:"username" json:"username"`
+	Kind      ReactionKind `bson:"kind" json:"kind"`
+	ReactedAt time.Time    `bson:"reacted_at" json:"reacted_at"`
+}
+
+// ReactionBreakdown lists the reactions on a post or comment grouped by kind
+type ReactionBreakdown struct {
+	Counts    map[ReactionKind]int        `json:"counts"`
+	Reactions map[ReactionKind][]Reaction `json:"reactions"`
+}
+
+// TrendingPost represents a post ranked by its recent engagement
+type TrendingPost struct {
+	Post  Post    `json:"post"`
+	Score float64 `json:"score"`
+}
+
+// TrendingHashtag represents a hashtag ranked by the recent engagement on posts using it
+type TrendingHashtag struct {
+	Tag   string  `json:"tag"`
+	Score float64 `json:"score"`
+}
+
 // PostReport represents a report of the number of posts created by each user
 type PostReport struct {
 	UserID   primitive.ObjectID `bson:"user_id" json:"user_id"`
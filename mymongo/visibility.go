@@ -0,0 +1,40 @@
+package mymongo
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// VisiblePostsFilter matches the posts a viewer may see: public posts, the viewer's own posts,
+// the friends-only posts of the authors listed in friendsOf, who have the viewer as a friend,
+// and the posts of the private groups listed in groups, which the viewer is a member of.
+// Posts by the authors listed in blockedBy, who have blocked the viewer, are never visible.
+// An empty viewer sees public posts only. prefix is prepended to the post fields, such as "post." after a $lookup.
+func VisiblePostsFilter(viewer string, friendsOf []string, blockedBy []string, groups []primitive.ObjectID, prefix string) bson.M {
+	// Posts stored before visibility levels existed have none and are public
+	conditions := bson.A{
+		bson.M{prefix + "visibility": bson.M{"$nin": bson.A{models.FriendsVisibility, models.PrivateVisibility, models.GroupVisibility}}},
+	}
+	if viewer != "" {
+		conditions = append(conditions, bson.M{prefix + "username": viewer})
+		if len(friendsOf) > 0 {
+			conditions = append(conditions, bson.M{
+				prefix + "visibility": models.FriendsVisibility,
+				prefix + "username":   bson.M{"$in": friendsOf},
+			})
+		}
+		if len(groups) > 0 {
+			conditions = append(conditions, bson.M{
+				prefix + "visibility": models.GroupVisibility,
+				prefix + "group_id":   bson.M{"$in": groups},
+			})
+		}
+	}
+	filter := bson.M{"$or": conditions}
+	if len(blockedBy) > 0 {
+		filter[prefix+"username"] = bson.M{"$nin": blockedBy}
+	}
+	return filter
+}
@@ -82,12 +82,22 @@ func createRandomComment(username string, postNumber int) {
 		return
 	}
 
+	// The comment is written by the post's author, who the server identifies by the X-Username header
 	url := fmt.Sprintf("http://localhost:80/user/%s/post/%d/comment", username, postNumber)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(commentJSON))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(commentJSON))
 	if err != nil {
 		fmt.Println("Error creating comment:", err)
 		return
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Username", username)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println("Error creating comment:", err)
+		return
+	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		fmt.Printf("Error creating comment: status code %d\n", resp.StatusCode)
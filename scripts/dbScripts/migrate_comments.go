@@ -0,0 +1,138 @@
+package dbScripts
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+	"github.com/alexander-winters/SENG468-A2/scripts/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// legacyPost holds the fields of a post document that still embeds its full comments
+type legacyPost struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Username   string             `bson:"username"`
+	PostNumber int                `bson:"post_number"`
+	Comments   []models.Comment   `bson:"comments"`
+}
+
+// legacyCommentFilter matches the comment document stored for an embedded comment. Comments were embedded
+// before they were given an ID or a post ID, so those without one are matched by author, content and creation time
+// among the comments not yet linked to another post.
+func legacyCommentFilter(postID primitive.ObjectID, comment models.Comment) bson.M {
+	if !comment.ID.IsZero() {
+		return bson.M{"_id": comment.ID}
+	}
+	return bson.M{
+		"username":   comment.Username,
+		"content":    comment.Content,
+		"created_at": comment.CreatedAt,
+		"post_id":    bson.M{"$in": bson.A{nil, primitive.NilObjectID, postID}},
+	}
+}
+
+// legacyCommentFields returns the fields of an embedded comment to store if it is missing from the comments collection,
+// leaving out its ID, which comes from the filter, and the fields set on every matched comment
+func legacyCommentFields(comment models.Comment) (bson.M, error) {
+	data, err := bson.Marshal(comment)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "_id")
+	delete(fields, "post_id")
+	delete(fields, "post_number")
+	return fields, nil
+}
+
+// MigrateEmbeddedComments moves comments embedded in post documents into the comments collection,
+// replacing them with the comment count and the latest comment previews
+func MigrateEmbeddedComments() {
+	ctx := context.Background()
+	database := db.GetMongoClient().Database("seng468-a2-db")
+	postsCollection := database.Collection("posts")
+	commentsCollection := database.Collection("comments")
+
+	// Find all posts that still embed comments
+	cursor, err := postsCollection.Find(ctx, bson.M{"comments": bson.M{"$exists": true}})
+	if err != nil {
+		log.Fatalf("Error finding posts: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var post legacyPost
+		if err := cursor.Decode(&post); err != nil {
+			log.Fatalf("Error decoding post: %v", err)
+		}
+
+		// Point the comment documents already stored for the embedded comments at their post,
+		// only storing the embedded comments that are missing from the comments collection
+		for _, comment := range post.Comments {
+			fields, err := legacyCommentFields(comment)
+			if err != nil {
+				log.Fatalf("Error serializing comment of post %s: %v", post.ID.Hex(), err)
+			}
+			update := bson.M{
+				"$set":         bson.M{"post_id": post.ID, "post_number": post.PostNumber},
+				"$setOnInsert": fields,
+			}
+			filter := legacyCommentFilter(post.ID, comment)
+			if _, err := commentsCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+				log.Fatalf("Error storing comment of post %s: %v", post.ID.Hex(), err)
+			}
+		}
+
+		// Count the post's comments and find the latest top-level ones
+		count, err := commentsCollection.CountDocuments(ctx, bson.M{"post_id": post.ID})
+		if err != nil {
+			log.Fatalf("Error counting comments of post %s: %v", post.ID.Hex(), err)
+		}
+
+		findOptions := options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+			SetLimit(models.LatestCommentsLimit)
+		latestCursor, err := commentsCollection.Find(ctx, bson.M{"post_id": post.ID, "parent_id": bson.M{"$exists": false}}, findOptions)
+		if err != nil {
+			log.Fatalf("Error finding comments of post %s: %v", post.ID.Hex(), err)
+		}
+		var latest []models.Comment
+		if err := latestCursor.All(ctx, &latest); err != nil {
+			log.Fatalf("Error decoding comments of post %s: %v", post.ID.Hex(), err)
+		}
+		previews := make([]models.Comment, 0, len(latest))
+		for i := len(latest) - 1; i >= 0; i-- {
+			previews = append(previews, latest[i])
+		}
+
+		// Replace the embedded comments with the count and previews
+		update := bson.M{
+			"$set":   bson.M{"number_of_comments": count, "latest_comments": previews},
+			"$unset": bson.M{"comments": ""},
+		}
+		if _, err := postsCollection.UpdateOne(ctx, bson.M{"_id": post.ID}, update); err != nil {
+			log.Fatalf("Error updating post %s: %v", post.ID.Hex(), err)
+		}
+
+		// Drop the cached copy of the post, which still embeds the comments
+		if err := rdb.Del(ctx, fmt.Sprintf("post:%s:%d", post.Username, post.PostNumber)).Err(); err != nil {
+			log.Fatalf("Error removing post %s from Redis: %v", post.ID.Hex(), err)
+		}
+
+		migrated++
+	}
+
+	if err := cursor.Err(); err != nil {
+		log.Fatalf("Error iterating posts: %v", err)
+	}
+
+	fmt.Printf("Migrated comments of %d posts.\n", migrated)
+}
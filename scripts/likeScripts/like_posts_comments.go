@@ -70,13 +70,15 @@ func readPostsAndComments(postsFilename, commentsFilename string) ([]Content, er
 	return contents, nil
 }
 
-func sendLikeRequest(url string) error {
+// sendLikeRequest likes a post or comment as username, who the server identifies by the X-Username header
+func sendLikeRequest(url string, username string) error {
 	client := &http.Client{}
 	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader("{}"))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Username", username)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -104,7 +106,7 @@ func randomlyLike(contents []Content) {
 		if likePost && !likedPosts[content.PostNumber] {
 			likedPosts[content.PostNumber] = true
 			postURL := fmt.Sprintf("http://localhost:80/user/%s/post/%d/like", content.Username, content.PostNumber)
-			err := sendLikeRequest(postURL)
+			err := sendLikeRequest(postURL, content.Username)
 			if err != nil {
 				fmt.Printf("Error liking post: %v\n", err)
 			} else {
@@ -115,7 +117,7 @@ func randomlyLike(contents []Content) {
 		if likeComment && !likedComments[content.CommentID] {
 			likedComments[content.CommentID] = true
 			commentURL := fmt.Sprintf("http://localhost:80/user/%s/post/%d/comment/%s/like", content.Username, content.PostNumber, content.CommentID)
-			err := sendLikeRequest(commentURL)
+			err := sendLikeRequest(commentURL, content.Username)
 			if err != nil {
 				fmt.Printf("Error liking comment: %v\n", err)
 			} else {
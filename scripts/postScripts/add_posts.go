@@ -69,12 +69,22 @@ func createRandomPost(username string) {
 		return
 	}
 
+	// Users can only post as themselves, who the server identifies by the X-Username header
 	url := fmt.Sprintf("http://localhost:80/user/%s/post", username)
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(postJSON))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(postJSON))
 	if err != nil {
 		fmt.Println("Error creating post:", err)
 		return
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Username", username)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Println("Error creating post:", err)
+		return
+	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		fmt.Printf("Error creating post: status code %d\n", resp.StatusCode)
@@ -25,6 +25,7 @@ func main() {
 	addFriends := flag.Bool("af", false, "Add random friends to users")
 	userReports := flag.String("r", "", "Download user reports for specified username")
 	generateLikes := flag.Bool("g", false, "Randomly like posts and comments")
+	migrateComments := flag.Bool("mc", false, "Move comments embedded in posts into the comments collection")
 	help := flag.Bool("h", false, "Display help information")
 	helpLong := flag.Bool("help", false, "Display help information")
 
@@ -49,6 +50,12 @@ func main() {
 		}
 	}
 
+	// Migrate embedded comments if the flag is set
+	if *migrateComments {
+		fmt.Println("Migrating embedded comments...")
+		dbScripts.MigrateEmbeddedComments()
+	}
+
 	// Create users if the flag is set
 	if *createUsers > 0 {
 		fmt.Println("Creating users...")
@@ -122,5 +129,6 @@ func displayHelp() {
 	fmt.Println("  -af           Add random friends to users")
 	fmt.Println("  -r <username> Get reports for specified username")
 	fmt.Println("  -g            Generate likes for posts and comments randomly")
+	fmt.Println("  -mc           Move comments embedded in posts into the comments collection")
 	fmt.Println("  -h, -help     Display help information")
 }
@@ -0,0 +1,212 @@
+package search
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// MongoBackend searches posts and comments through MongoDB text indexes, and users by username and name prefix
+type MongoBackend struct {
+	Database *mongo.Database
+}
+
+func NewMongoBackend(database *mongo.Database) *MongoBackend {
+	return &MongoBackend{Database: database}
+}
+
+func (b *MongoBackend) Search(ctx context.Context, query Query) ([]Result, error) {
+	types := query.Types
+	if len(types) == 0 {
+		types = Types
+	}
+
+	if query.Skip < 0 {
+		query.Skip = 0
+	}
+
+	// Each kind is searched for enough results to fill the page, then all kinds are merged by score
+	limit := int64(query.Skip + query.Limit)
+	var results []Result
+	for _, t := range types {
+		var found []Result
+		var err error
+		switch t {
+		case UserType:
+			found, err = b.searchUsers(ctx, query, limit)
+		case PostType:
+			found, err = b.searchPosts(ctx, query, limit)
+		case CommentType:
+			found, err = b.searchComments(ctx, query, limit)
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, found...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if query.Skip >= len(results) {
+		return []Result{}, nil
+	}
+	results = results[query.Skip:]
+	if len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results, nil
+}
+
+// filter builds the conditions shared by every kind of document: not deleted and created in the query's time range
+func (b *MongoBackend) filter(query Query) bson.M {
+	filter := bson.M{"deleted_at": bson.M{"$exists": false}}
+	created := bson.M{}
+	if query.From != nil {
+		created["$gte"] = *query.From
+	}
+	if query.To != nil {
+		created["$lt"] = *query.To
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	return filter
+}
+
+// textFilter adds the text search and author conditions used for posts and comments
+func (b *MongoBackend) textFilter(query Query) bson.M {
+	filter := b.filter(query)
+	filter["$text"] = bson.M{"$search": query.Text}
+	if query.Author != "" {
+		filter["username"] = query.Author
+	}
+	return filter
+}
+
+// textOptions sorts text search results by relevance, keeping the score on each document
+func textOptions(limit int64) *options.FindOptions {
+	score := bson.M{"$meta": "textScore"}
+	return options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "created_at", Value: -1}}).
+		SetLimit(limit)
+}
+
+// searchUsers finds users whose username, first name or last name starts with the query text,
+// leaving out the users who have blocked the viewer.
+// Exact username matches rank first, then username prefixes, then name prefixes.
+func (b *MongoBackend) searchUsers(ctx context.Context, query Query, limit int64) ([]Result, error) {
+	prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query.Text), Options: "i"}
+	filter := b.filter(query)
+	filter["$or"] = bson.A{
+		bson.M{"username": prefix},
+		bson.M{"first_name": prefix},
+		bson.M{"last_name": prefix},
+	}
+	if len(query.BlockedBy) > 0 {
+		filter["username"] = bson.M{"$nin": query.BlockedBy}
+	}
+
+	// Score the matches before limiting them, so that the best matches are kept
+	username := bson.M{"$toLower": "$username"}
+	text := strings.ToLower(query.Text)
+	score := bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": bson.M{"$eq": bson.A{username, text}}, "then": 3},
+			bson.M{"case": bson.M{"$eq": bson.A{bson.M{"$indexOfCP": bson.A{username, text}}, 0}}, "then": 2},
+		},
+		"default": 1,
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$addFields", Value: bson.M{"score": score}}},
+		{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "username", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{"password": 0, "notifications": 0}}},
+	}
+	cursor, err := b.Database.Collection("users").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var users []struct {
+		models.User `bson:",inline"`
+		Score       float64 `bson:"score"`
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(users))
+	for i := range users {
+		profile := users[i].PublicProfile()
+		results = append(results, Result{Type: UserType, Score: users[i].Score, User: &profile})
+	}
+	return results, nil
+}
+
+// searchPosts finds posts the viewer may see matching the query text through the posts text index
+func (b *MongoBackend) searchPosts(ctx context.Context, query Query, limit int64) ([]Result, error) {
+	filter := b.textFilter(query)
+	filter["$and"] = bson.A{mymongo.VisiblePostsFilter(query.Viewer, query.FriendsOf, query.BlockedBy, query.Groups, "")}
+	cursor, err := b.Database.Collection("posts").Find(ctx, filter, textOptions(limit))
+	if err != nil {
+		return nil, err
+	}
+	var posts []struct {
+		models.Post `bson:",inline"`
+		Score       float64 `bson:"score"`
+	}
+	if err := cursor.All(ctx, &posts); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(posts))
+	for i := range posts {
+		results = append(results, Result{Type: PostType, Score: posts[i].Score, Post: &posts[i].Post})
+	}
+	return results, nil
+}
+
+// searchComments finds comments matching the query text through the comments text index,
+// keeping only those on live posts the viewer may see
+func (b *MongoBackend) searchComments(ctx context.Context, query Query, limit int64) ([]Result, error) {
+	postFilter := mymongo.VisiblePostsFilter(query.Viewer, query.FriendsOf, query.BlockedBy, query.Groups, "post.")
+	postFilter["post.deleted_at"] = bson.M{"$exists": false}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: b.textFilter(query)}},
+		{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "created_at", Value: -1}}}},
+		{{Key: "$lookup", Value: bson.M{"from": "posts", "localField": "post_id", "foreignField": "_id", "as": "post"}}},
+		{{Key: "$unwind", Value: "$post"}},
+		{{Key: "$match", Value: postFilter}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$project", Value: bson.M{"post": 0}}},
+	}
+	cursor, err := b.Database.Collection("comments").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var comments []struct {
+		models.Comment `bson:",inline"`
+		Score          float64 `bson:"score"`
+	}
+	if err := cursor.All(ctx, &comments); err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(comments))
+	for i := range comments {
+		results = append(results, Result{Type: CommentType, Score: comments[i].Score, Comment: &comments[i].Comment})
+	}
+	return results, nil
+}
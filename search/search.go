@@ -0,0 +1,60 @@
+package search
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// Type is the kind of document a search can return
+type Type string
+
+const (
+	UserType    Type = "users"
+	PostType    Type = "posts"
+	CommentType Type = "comments"
+)
+
+// Types lists every kind of document that can be searched
+var Types = []Type{UserType, PostType, CommentType}
+
+// Query describes a search
+type Query struct {
+	// Text is matched against the content of posts and comments, and as a prefix of usernames and names
+	Text string
+	// Types limits the kinds of documents returned, all kinds being searched if it is empty
+	Types []Type
+	// Author, if set, limits posts and comments to those written by this username
+	Author string
+	// From and To, if set, limit results to those created in this time range
+	From *time.Time
+	To   *time.Time
+	// Viewer is the user searching, who only finds the posts, and comments on posts, that they may see.
+	// FriendsOf lists the users who have the viewer as a friend, whose friends-only posts the viewer may see,
+	// BlockedBy the users who have blocked the viewer, whose posts the viewer may not see,
+	// and Groups the private groups the viewer is a member of, whose posts the viewer may see.
+	Viewer    string
+	FriendsOf []string
+	BlockedBy []string
+	Groups    []primitive.ObjectID
+	// Skip and Limit select a page of the results, ordered by relevance
+	Skip  int
+	Limit int
+}
+
+// Result is a document matching a search along with its relevance score
+type Result struct {
+	Type    Type                  `json:"type"`
+	Score   float64               `json:"score"`
+	User    *models.PublicProfile `json:"user,omitempty"`
+	Post    *models.Post          `json:"post,omitempty"`
+	Comment *models.Comment       `json:"comment,omitempty"`
+}
+
+// Backend runs searches, such as against MongoDB text indexes or an embedded search index
+type Backend interface {
+	Search(ctx context.Context, query Query) ([]Result, error)
+}
@@ -0,0 +1,509 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// accountDeletion tracks an account being deleted and the posts and comments whose counters it affects
+type accountDeletion struct {
+	user      *models.User
+	anonymize bool
+	posts     map[primitive.ObjectID]bool
+	comments  map[primitive.ObjectID]bool
+}
+
+// accountDeletionStep is one step of deleting an account
+type accountDeletionStep struct {
+	name string
+	run  func(ctx context.Context, d *accountDeletion) error
+}
+
+// accountDeletionSteps are the steps of deleting an account, in the order they run
+var accountDeletionSteps = []accountDeletionStep{
+	{"posts", removeAccountPosts},
+	{"comments", removeAccountComments},
+	{"interactions", removeAccountInteractions},
+	{"friends", removeAccountFriends},
+	{"messages", removeAccountMessages},
+	{"groups", removeAccountGroups},
+	{"notifications", removeAccountNotifications},
+	{"counters", recomputeAccountCounters},
+	{"account", removeAccount},
+}
+
+// anonymousUsername is the name shown in place of a deleted user on the posts and comments they leave behind
+func anonymousUsername(user *models.User) string {
+	return "deleted-" + user.ID.Hex()
+}
+
+// deleteAccount removes everything tied to a user, or with anonymize set keeps their posts and comments under an anonymous name.
+// progress, if not nil, is called before each step with the number of steps completed so far.
+func deleteAccount(ctx context.Context, user *models.User, anonymize bool, progress func(step string, completed int)) error {
+	d := &accountDeletion{
+		user:      user,
+		anonymize: anonymize,
+		posts:     map[primitive.ObjectID]bool{},
+		comments:  map[primitive.ObjectID]bool{},
+	}
+
+	for i, step := range accountDeletionSteps {
+		if progress != nil {
+			progress(step.name, i)
+		}
+		if err := step.run(ctx, d); err != nil {
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+// removeAccountPosts removes the user's drafts, stories and posts, or moves the posts to the anonymous name
+func removeAccountPosts(ctx context.Context, d *accountDeletion) error {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	draftsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("drafts")
+	sharesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("shares")
+	storiesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("stories")
+
+	if _, err := draftsCollection.DeleteMany(ctx, bson.M{"username": d.user.Username}); err != nil {
+		return err
+	}
+	stories, err := objectIDs(ctx, storiesCollection, bson.M{"username": d.user.Username})
+	if err != nil {
+		return err
+	}
+	if err := deleteStories(ctx, stories); err != nil {
+		return err
+	}
+
+	cursor, err := postsCollection.Find(ctx, bson.M{"username": d.user.Username})
+	if err != nil {
+		return err
+	}
+	var posts []models.Post
+	if err := cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+
+	if !d.anonymize {
+		for i := range posts {
+			if err := purgePost(ctx, &posts[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	update := bson.M{"$set": bson.M{"username": anonymousUsername(d.user), "user_id": primitive.NilObjectID}}
+	if _, err := postsCollection.UpdateMany(ctx, bson.M{"username": d.user.Username}, update); err != nil {
+		return err
+	}
+	update = bson.M{"$set": bson.M{"post_author": anonymousUsername(d.user)}}
+	if _, err := sharesCollection.UpdateMany(ctx, bson.M{"post_author": d.user.Username}, update); err != nil {
+		return err
+	}
+	for _, post := range posts {
+		if err := rdb.Del(ctx, fmt.Sprintf("post:%s:%d", post.Username, post.PostNumber)).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAccountComments removes the user's comments, or moves them to the anonymous name.
+// Replies other users left below removed comments move up to the removed comment's parent.
+func removeAccountComments(ctx context.Context, d *accountDeletion) error {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	cursor, err := commentsCollection.Find(ctx, bson.M{"username": d.user.Username})
+	if err != nil {
+		return err
+	}
+	var comments []models.Comment
+	if err := cursor.All(ctx, &comments); err != nil {
+		return err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(comments))
+	for _, comment := range comments {
+		ids = append(ids, comment.ID)
+		d.posts[comment.PostID] = true
+		if !comment.ParentID.IsZero() {
+			d.comments[comment.ParentID] = true
+		}
+	}
+
+	if !d.anonymize {
+		return removeComments(ctx, comments)
+	}
+
+	update := bson.M{"$set": bson.M{"username": anonymousUsername(d.user), "user_id": primitive.NilObjectID}}
+	if _, err := commentsCollection.UpdateMany(ctx, bson.M{"username": d.user.Username}, update); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return rdb.Del(ctx, commentKeys(ids)...).Err()
+}
+
+// removeAccountInteractions removes the likes, reactions, shares and poll votes the user left on posts and comments,
+// and the record of the stories they viewed
+func removeAccountInteractions(ctx context.Context, d *accountDeletion) error {
+	database := mymongo.GetMongoClient().Database("seng468-a2-db")
+
+	// Remove the user's story views, taking them off the stories' view counts
+	viewedStories, err := database.Collection("story_views").Distinct(ctx, "story_id", bson.M{"username": d.user.Username})
+	if err != nil {
+		return err
+	}
+	for _, id := range viewedStories {
+		storyID, ok := id.(primitive.ObjectID)
+		if !ok {
+			continue
+		}
+		if _, err := database.Collection("stories").UpdateOne(ctx, bson.M{"_id": storyID}, bson.M{"$inc": bson.M{"number_of_views": -1}}); err != nil {
+			return err
+		}
+		if err := rdb.Del(ctx, storyKey(storyID)).Err(); err != nil {
+			return err
+		}
+	}
+	if _, err := database.Collection("story_views").DeleteMany(ctx, bson.M{"username": d.user.Username}); err != nil {
+		return err
+	}
+
+	// Remove the user's shares, recording the shared posts so their share counts are recomputed afterwards
+	cursor, err := database.Collection("shares").Find(ctx, bson.M{"username": d.user.Username})
+	if err != nil {
+		return err
+	}
+	var shares []models.Share
+	if err := cursor.All(ctx, &shares); err != nil {
+		return err
+	}
+	for _, share := range shares {
+		d.posts[share.PostID] = true
+	}
+	if _, err := database.Collection("shares").DeleteMany(ctx, bson.M{"username": d.user.Username}); err != nil {
+		return err
+	}
+
+	// Remove the user's poll votes, recording the posts so their polls are recounted afterwards
+	votedPosts, err := database.Collection("poll_votes").Distinct(ctx, "post_id", bson.M{"username": d.user.Username})
+	if err != nil {
+		return err
+	}
+	for _, id := range votedPosts {
+		if postID, ok := id.(primitive.ObjectID); ok {
+			d.posts[postID] = true
+		}
+	}
+	if _, err := database.Collection("poll_votes").DeleteMany(ctx, bson.M{"username": d.user.Username}); err != nil {
+		return err
+	}
+
+	filter := bson.M{"$or": bson.A{bson.M{"likes.username": d.user.Username}, bson.M{"reactions.username": d.user.Username}}}
+	update := bson.M{"$pull": bson.M{
+		"likes":     bson.M{"username": d.user.Username},
+		"reactions": bson.M{"username": d.user.Username},
+	}}
+
+	for name, affected := range map[string]map[primitive.ObjectID]bool{"posts": d.posts, "comments": d.comments} {
+		collection := database.Collection(name)
+
+		// Record the affected documents so their counters are recomputed afterwards
+		ids, err := objectIDs(ctx, collection, filter)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			affected[id] = true
+		}
+
+		if _, err := collection.UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAccountFriends removes the user from other users' friend lists and block lists
+func removeAccountFriends(ctx context.Context, d *accountDeletion) error {
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	filter := bson.M{"$or": bson.A{bson.M{"list_of_friends": d.user.Username}, bson.M{"blocked_users": d.user.Username}}}
+	cursor, err := usersCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"username": 1}))
+	if err != nil {
+		return err
+	}
+	var friends []models.User
+	if err := cursor.All(ctx, &friends); err != nil {
+		return err
+	}
+
+	update := bson.M{"$pull": bson.M{"list_of_friends": d.user.Username, "blocked_users": d.user.Username}}
+	if _, err := usersCollection.UpdateMany(ctx, filter, update); err != nil {
+		return err
+	}
+	for _, friend := range friends {
+		if err := rdb.Del(ctx, "user:"+friend.Username).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAccountMessages removes the user from their conversations, along with the messages they sent, or moves those messages to the anonymous name.
+// Conversations left with a single participant are removed together with their messages.
+func removeAccountMessages(ctx context.Context, d *accountDeletion) error {
+	conversationsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("conversations")
+	messagesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("messages")
+
+	if d.anonymize {
+		update := bson.M{"$set": bson.M{"username": anonymousUsername(d.user)}}
+		if _, err := messagesCollection.UpdateMany(ctx, bson.M{"username": d.user.Username}, update); err != nil {
+			return err
+		}
+	} else if _, err := messagesCollection.DeleteMany(ctx, bson.M{"username": d.user.Username}); err != nil {
+		return err
+	}
+
+	filter := bson.M{"members.username": d.user.Username}
+	if _, err := conversationsCollection.UpdateMany(ctx, filter, bson.M{"$pull": bson.M{"members": bson.M{"username": d.user.Username}}}); err != nil {
+		return err
+	}
+
+	abandoned, err := objectIDs(ctx, conversationsCollection, bson.M{"members.1": bson.M{"$exists": false}})
+	if err != nil || len(abandoned) == 0 {
+		return err
+	}
+	if _, err := messagesCollection.DeleteMany(ctx, bson.M{"conversation_id": bson.M{"$in": abandoned}}); err != nil {
+		return err
+	}
+	_, err = conversationsCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": abandoned}})
+	return err
+}
+
+// removeAccountGroups removes the user's group memberships, handing each group they own over to its longest-standing moderator,
+// or member if it has no moderators. Groups left without members are deleted.
+func removeAccountGroups(ctx context.Context, d *accountDeletion) error {
+	groupsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("groups")
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	owned, err := objectIDs(ctx, groupsCollection, bson.M{"owner": d.user.Username})
+	if err != nil {
+		return err
+	}
+	for _, groupID := range owned {
+		filter := bson.M{"group_id": groupID, "status": models.ActiveMembership, "username": bson.M{"$ne": d.user.Username}}
+		opts := options.FindOne().SetSort(bson.D{{Key: "role", Value: -1}, {Key: "joined_at", Value: 1}, {Key: "_id", Value: 1}})
+		var successor models.GroupMembership
+		if err := membershipsCollection.FindOne(ctx, filter, opts).Decode(&successor); err != nil {
+			if err != mongo.ErrNoDocuments {
+				return err
+			}
+			if err := deleteGroup(ctx, groupID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := membershipsCollection.UpdateOne(ctx, bson.M{"_id": successor.ID}, bson.M{"$set": bson.M{"role": models.GroupOwner}}); err != nil {
+			return err
+		}
+		if _, err := groupsCollection.UpdateOne(ctx, bson.M{"_id": groupID}, bson.M{"$set": bson.M{"owner": successor.Username}}); err != nil {
+			return err
+		}
+	}
+
+	_, err = membershipsCollection.DeleteMany(ctx, bson.M{"username": d.user.Username})
+	return err
+}
+
+// removeAccountNotifications removes the notifications sent to or by the user
+func removeAccountNotifications(ctx context.Context, d *accountDeletion) error {
+	notificationsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("notifications")
+
+	filter := bson.M{"$or": bson.A{bson.M{"recipient": d.user.Username}, bson.M{"username": d.user.Username}}}
+	_, err := notificationsCollection.DeleteMany(ctx, filter)
+	return err
+}
+
+// recomputeAccountCounters recounts the likes, reactions, comments, replies, shares and poll votes of the posts and comments the deletion affected
+func recomputeAccountCounters(ctx context.Context, d *accountDeletion) error {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+	sharesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("shares")
+
+	for id := range d.comments {
+		var comment models.Comment
+		if err := commentsCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&comment); err != nil {
+			if err == mongo.ErrNoDocuments {
+				continue
+			}
+			return err
+		}
+		replies, err := commentsCollection.CountDocuments(ctx, notDeleted(bson.M{"parent_id": id}))
+		if err != nil {
+			return err
+		}
+
+		update := bson.M{"$set": bson.M{
+			"number_of_likes":   len(comment.Likes),
+			"reaction_counts":   reactionBreakdown(comment.Reactions).Counts,
+			"number_of_replies": replies,
+		}}
+		if _, err := commentsCollection.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+			return err
+		}
+		if err := rdb.Del(ctx, "comment:"+id.Hex()).Err(); err != nil {
+			return err
+		}
+		d.posts[comment.PostID] = true
+	}
+
+	for id := range d.posts {
+		var post models.Post
+		if err := postsCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&post); err != nil {
+			if err == mongo.ErrNoDocuments {
+				continue
+			}
+			return err
+		}
+		comments, err := commentsCollection.CountDocuments(ctx, notDeleted(bson.M{"post_id": id}))
+		if err != nil {
+			return err
+		}
+		shares, err := sharesCollection.CountDocuments(ctx, bson.M{"post_id": id})
+		if err != nil {
+			return err
+		}
+
+		update := bson.M{"$set": bson.M{
+			"number_of_likes":    len(post.Likes),
+			"reaction_counts":    reactionBreakdown(post.Reactions).Counts,
+			"number_of_comments": comments,
+			"number_of_shares":   shares,
+		}}
+		if _, err := postsCollection.UpdateOne(ctx, bson.M{"_id": id}, update); err != nil {
+			return err
+		}
+		if err := refreshLatestComments(ctx, id); err != nil {
+			return err
+		}
+		if post.Poll != nil {
+			if err := recountPoll(ctx, &post); err != nil {
+				return err
+			}
+		}
+		if err := rdb.Del(ctx, fmt.Sprintf("post:%s:%d", post.Username, post.PostNumber)).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeAccount removes the user's name from the revisions they edited, their bookmarks, then the user itself
+func removeAccount(ctx context.Context, d *accountDeletion) error {
+	database := mymongo.GetMongoClient().Database("seng468-a2-db")
+
+	if _, err := database.Collection("bookmarks").DeleteMany(ctx, bson.M{"username": d.user.Username}); err != nil {
+		return err
+	}
+	if _, err := database.Collection("bookmark_collections").DeleteMany(ctx, bson.M{"username": d.user.Username}); err != nil {
+		return err
+	}
+
+	update := bson.M{"$set": bson.M{"editor": anonymousUsername(d.user)}}
+	if _, err := database.Collection("revisions").UpdateMany(ctx, bson.M{"editor": d.user.Username}, update); err != nil {
+		return err
+	}
+
+	if err := deleteAvatarFiles(ctx, d.user); err != nil {
+		return err
+	}
+
+	if _, err := database.Collection("users").DeleteOne(ctx, bson.M{"_id": d.user.ID}); err != nil {
+		return err
+	}
+	return rdb.Del(ctx, "user:"+d.user.Username, d.user.Username, suggestionsKey(d.user.Username)).Err()
+}
+
+// StartAccountDeletion starts a background job that permanently deletes a user and everything tied to them.
+// With the anonymize query parameter set, the user's posts and comments are kept under an anonymous name instead.
+func StartAccountDeletion(c *fiber.Ctx) error {
+	// Get a handle to the users collection
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	// Get the username from the URL parameters, users can only delete their own account
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only delete their own account",
+		})
+	}
+	anonymize := c.Query("anonymize") == "true"
+
+	// Retrieve the user, whether or not it is in the trash, preferring the live account over trashed ones
+	var user models.User
+	opts := options.FindOne().SetSort(bson.D{{Key: "deleted_at", Value: 1}})
+	if err := usersCollection.FindOne(c.Context(), bson.M{"username": username}, opts).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+
+	// Hide the account straight away while the job runs
+	if user.DeletedAt == nil {
+		update := bson.M{"$set": bson.M{"deleted_at": time.Now()}}
+		if _, err := usersCollection.UpdateOne(c.Context(), bson.M{"_id": user.ID}, update); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not update user in database",
+			})
+		}
+	}
+	if err := rdb.Del(c.Context(), "user:"+user.Username, user.Username).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove user from Redis",
+		})
+	}
+
+	// Create the job that tracks the deletion
+	job, err := createJob(c.Context(), models.AccountDeletionJob, user.Username, len(accountDeletionSteps))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not create account deletion job",
+		})
+	}
+
+	// Delete the account in the background
+	go func() {
+		ctx := context.Background()
+		err := deleteAccount(ctx, &user, anonymize, func(step string, completed int) {
+			updateJobProgress(ctx, job.ID, step, completed)
+		})
+		if err != nil {
+			log.Printf("Could not delete account %s: %v", user.Username, err)
+		}
+		finishJob(ctx, job, err, nil)
+	}()
+
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetCurrentUser stores the username of the requesting user, taken from the X-Username header, in the request locals
+func SetCurrentUser(c *fiber.Ctx) error {
+	if username := c.Get("X-Username"); username != "" {
+		c.Locals("username", username)
+	}
+	return c.Next()
+}
+
+// currentUsername returns the username of the requesting user, or an empty string if none was provided
+func currentUsername(c *fiber.Ctx) string {
+	username, _ := c.Locals("username").(string)
+	return username
+}
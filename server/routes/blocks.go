@@ -0,0 +1,195 @@
+package routes
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// hasBlocked reports whether blocker has blocked the user named blocked
+func hasBlocked(blocker, blocked string) (bool, error) {
+	user, err := GetUserByUsername(blocker)
+	if err != nil {
+		return false, err
+	}
+	for _, username := range user.BlockedUsers {
+		if username == blocked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// blockedBy returns the users who have blocked username
+func blockedBy(ctx context.Context, username string) ([]string, error) {
+	if username == "" {
+		return nil, nil
+	}
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	filter := notDeleted(bson.M{"blocked_users": username})
+	cursor, err := usersCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"username": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	return usernames, nil
+}
+
+// errNotBlocker is returned when a user tries to manage or read another user's block list
+var errNotBlocker = errors.New("not the blocking user")
+
+// blockError writes the response for an error returned while retrieving the users of a block
+func blockError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errNotBlocker:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only manage their own block list",
+		})
+	case mongo.ErrNoDocuments:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve user",
+	})
+}
+
+// findBlocker retrieves the user named by the username route parameter, who must be the requesting user
+func findBlocker(c *fiber.Ctx) (*models.User, error) {
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return nil, errNotBlocker
+	}
+	return GetUserByUsername(username)
+}
+
+// findBlockPair retrieves the blocking user and the user being blocked or unblocked from the route parameters
+func findBlockPair(c *fiber.Ctx) (*models.User, *models.User, error) {
+	blocker, err := findBlocker(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	blocked, err := GetUserByUsername(c.Params("blocked_username"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return blocker, blocked, nil
+}
+
+// BlockUser adds a user to another user's block list and ends any friendship between them.
+// Blocked users cannot see the blocker's posts, and so cannot comment on or like them, and do not notify the blocker.
+func BlockUser(c *fiber.Ctx) error {
+	// Get a handle to the users collection
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	// Retrieve both users
+	blocker, blocked, err := findBlockPair(c)
+	if err != nil {
+		return blockError(c, err)
+	}
+	if blocker.Username == blocked.Username {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Users cannot block themselves",
+		})
+	}
+
+	// Add the user to the block list and remove them as a friend
+	update := bson.M{
+		"$addToSet": bson.M{"blocked_users": blocked.Username},
+		"$pull":     bson.M{"list_of_friends": blocked.Username},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := usersCollection.FindOneAndUpdate(c.Context(), bson.M{"_id": blocker.ID}, update, opts).Decode(blocker); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update user in database",
+		})
+	}
+
+	// Remove the blocker from the blocked user's friends
+	update = bson.M{"$pull": bson.M{"list_of_friends": blocker.Username}}
+	if _, err := usersCollection.UpdateOne(c.Context(), bson.M{"_id": blocked.ID}, update); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update user in database",
+		})
+	}
+
+	// Remove both users from Redis cache
+	if err := rdb.Del(c.Context(), "user:"+blocker.Username, blocker.Username, "user:"+blocked.Username, blocked.Username).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove user from Redis",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"blocked_users": blocker.BlockedUsers,
+	})
+}
+
+// UnblockUser removes a user from another user's block list. Friendships ended by the block are not restored.
+func UnblockUser(c *fiber.Ctx) error {
+	// Get a handle to the users collection
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	// Retrieve both users
+	blocker, blocked, err := findBlockPair(c)
+	if err != nil {
+		return blockError(c, err)
+	}
+
+	// Remove the user from the block list
+	update := bson.M{"$pull": bson.M{"blocked_users": blocked.Username}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := usersCollection.FindOneAndUpdate(c.Context(), bson.M{"_id": blocker.ID}, update, opts).Decode(blocker); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update user in database",
+		})
+	}
+
+	// Remove the user from Redis cache
+	if err := rdb.Del(c.Context(), "user:"+blocker.Username, blocker.Username).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove user from Redis",
+		})
+	}
+
+	if blocker.BlockedUsers == nil {
+		blocker.BlockedUsers = []string{}
+	}
+	return c.JSON(fiber.Map{
+		"blocked_users": blocker.BlockedUsers,
+	})
+}
+
+// ListBlockedUsers retrieves the users the requesting user has blocked
+func ListBlockedUsers(c *fiber.Ctx) error {
+	// Retrieve the user, whose block list is private
+	user, err := findBlocker(c)
+	if err != nil {
+		return blockError(c, err)
+	}
+
+	blockedUsers := user.BlockedUsers
+	if blockedUsers == nil {
+		blockedUsers = []string{}
+	}
+	return c.JSON(fiber.Map{
+		"blocked_users": blockedUsers,
+	})
+}
@@ -0,0 +1,457 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// maxCollectionNameLength is the longest name, in characters, a bookmark collection can have
+const maxCollectionNameLength = 50
+
+// Errors returned while finding or changing bookmarks
+var (
+	errNotBookmarkOwner    = errors.New("not the bookmark owner")
+	errUnknownBookmarker   = errors.New("unknown bookmarking user")
+	errCollectionName      = errors.New("invalid collection name")
+	errCollectionNotFound  = errors.New("collection not found")
+	errCollectionDuplicate = errors.New("collection already exists")
+)
+
+// bookmarkError writes the response for an error returned while finding or changing bookmarks and their collections
+func bookmarkError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errNotBookmarkOwner:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Bookmarks are only available to their owner",
+		})
+	case errUnknownBookmarker:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown user",
+		})
+	case errCollectionName:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Collection names must be 1 to %d characters", maxCollectionNameLength),
+		})
+	case errCollectionNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Collection not found",
+		})
+	case errCollectionDuplicate:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Collection already exists",
+		})
+	case mongo.ErrNoDocuments:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Post not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve bookmarks",
+	})
+}
+
+// collectionName cleans a bookmark collection name, which must not be empty
+func collectionName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxCollectionNameLength {
+		return "", errCollectionName
+	}
+	return name, nil
+}
+
+// collectionParam reads the name of the bookmark collection in the collection route parameter
+func collectionParam(c *fiber.Ctx) string {
+	name, err := url.PathUnescape(c.Params("collection"))
+	if err != nil {
+		return c.Params("collection")
+	}
+	return name
+}
+
+// findBookmarkOwner retrieves the user named by the username route parameter, who must be the requesting user
+func findBookmarkOwner(c *fiber.Ctx) (*models.User, error) {
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return nil, errNotBookmarkOwner
+	}
+	return GetUserByUsername(username)
+}
+
+// checkCollection makes sure a user has a bookmark collection with the given name
+func checkCollection(c *fiber.Ctx, username, name string) error {
+	collectionsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmark_collections")
+
+	count, err := collectionsCollection.CountDocuments(c.Context(), bson.M{"username": username, "name": name})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errCollectionNotFound
+	}
+	return nil
+}
+
+// BookmarkPost saves a post the requesting user may see to their bookmarks.
+// The collection field of the request body files it in one of their collections; bookmarking again moves it.
+func BookmarkPost(c *fiber.Ctx) error {
+	// Get a handle to the bookmarks collection
+	bookmarksCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmarks")
+
+	// Parse the request body into a struct
+	var body struct {
+		Collection string `json:"collection"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Could not parse request body",
+			})
+		}
+	}
+
+	// Retrieve the bookmarking user and the post
+	user, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return bookmarkError(c, errUnknownBookmarker)
+		}
+		return bookmarkError(c, err)
+	}
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+	post, err := findVisiblePost(c, c.Params("username"), postNumber)
+	if err != nil {
+		return bookmarkError(c, err)
+	}
+
+	// Check the collection exists, if one was given
+	var collection string
+	if body.Collection != "" {
+		if collection, err = collectionName(body.Collection); err != nil {
+			return bookmarkError(c, err)
+		}
+		if err := checkCollection(c, user.Username, collection); err != nil {
+			return bookmarkError(c, err)
+		}
+	}
+
+	// Save the bookmark, keeping its save time if it already existed
+	filter := bson.M{"username": user.Username, "post_id": post.ID}
+	update := bson.M{
+		"$set":         bson.M{"collection": collection},
+		"$setOnInsert": bson.M{"created_at": time.Now()},
+	}
+	if collection == "" {
+		update["$unset"] = bson.M{"collection": ""}
+		delete(update, "$set")
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var bookmark models.Bookmark
+	if err := bookmarksCollection.FindOneAndUpdate(c.Context(), filter, update, opts).Decode(&bookmark); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store bookmark in database",
+		})
+	}
+
+	bookmark.Post = post
+	return c.JSON(bookmark)
+}
+
+// UnbookmarkPost removes a post from the requesting user's bookmarks
+func UnbookmarkPost(c *fiber.Ctx) error {
+	// Get a handle to the posts and bookmarks collections
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	bookmarksCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmarks")
+
+	// Find the post, even if it was deleted or is no longer visible, so stale bookmarks can still be removed
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+	var post models.Post
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	if err := postsCollection.FindOne(c.Context(), bson.M{"username": c.Params("username"), "post_number": postNumber}, opts).Decode(&post); err != nil {
+		return bookmarkError(c, err)
+	}
+
+	// Remove the bookmark
+	res, err := bookmarksCollection.DeleteOne(c.Context(), bson.M{"username": currentUsername(c), "post_id": post.ID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete bookmark from database",
+		})
+	}
+	if res.DeletedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Post not bookmarked",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Bookmark removed",
+	})
+}
+
+// ListBookmarks retrieves a page of the requesting user's bookmarks with their posts, most recently saved first.
+// The collection query parameter limits them to one collection.
+// Bookmarks of posts that were deleted or are no longer visible to the user are left out.
+func ListBookmarks(c *fiber.Ctx) error {
+	// Get a handle to the posts and bookmarks collections
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	bookmarksCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmarks")
+
+	// Retrieve the user
+	user, err := findBookmarkOwner(c)
+	if err != nil {
+		return bookmarkError(c, err)
+	}
+
+	// Find the bookmarks of posts the user may still see
+	filter := bson.M{"username": user.Username}
+	if name := c.Query("collection"); name != "" {
+		collection, err := collectionName(name)
+		if err != nil {
+			return bookmarkError(c, err)
+		}
+		filter["collection"] = collection
+	}
+	postsFilter, err := visiblePosts(c.Context(), user.Username, notDeleted(bson.M{}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+
+	// Join the bookmarks to their posts, keeping the requested page
+	page, limit := pageParams(c)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": postsCollection.Name(),
+			"let":  bson.M{"post_id": "$post_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$_id", "$$post_id"}}}},
+				bson.M{"$match": postsFilter},
+			},
+			"as": "post",
+		}}},
+		{{Key: "$unwind", Value: "$post"}},
+		{{Key: "$skip", Value: int64((page - 1) * limit)}},
+		{{Key: "$limit", Value: int64(limit)}},
+	}
+	cursor, err := bookmarksCollection.Aggregate(c.Context(), pipeline)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve bookmarks from database",
+		})
+	}
+	var docs []struct {
+		models.Bookmark `bson:",inline"`
+		Post            models.Post `bson:"post"`
+	}
+	if err := cursor.All(c.Context(), &docs); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve bookmarks from database",
+		})
+	}
+
+	bookmarks := make([]models.Bookmark, 0, len(docs))
+	for i := range docs {
+		bookmark := docs[i].Bookmark
+		bookmark.Post = &docs[i].Post
+		bookmarks = append(bookmarks, bookmark)
+	}
+	return c.JSON(bookmarks)
+}
+
+// CreateBookmarkCollection creates a named collection for the requesting user's bookmarks
+func CreateBookmarkCollection(c *fiber.Ctx) error {
+	// Get a handle to the bookmark collections collection
+	collectionsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmark_collections")
+
+	// Retrieve the user
+	user, err := findBookmarkOwner(c)
+	if err != nil {
+		return bookmarkError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+	name, err := collectionName(body.Name)
+	if err != nil {
+		return bookmarkError(c, err)
+	}
+
+	// Insert the collection
+	collection := models.BookmarkCollection{
+		ID:        primitive.NewObjectID(),
+		Username:  user.Username,
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	if _, err := collectionsCollection.InsertOne(c.Context(), collection); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return bookmarkError(c, errCollectionDuplicate)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert collection into database",
+		})
+	}
+
+	return c.JSON(collection)
+}
+
+// ListBookmarkCollections retrieves the requesting user's bookmark collections by name, with how many bookmarks each holds
+func ListBookmarkCollections(c *fiber.Ctx) error {
+	// Get a handle to the bookmarks and bookmark collections collections
+	bookmarksCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmarks")
+	collectionsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmark_collections")
+
+	// Retrieve the user
+	user, err := findBookmarkOwner(c)
+	if err != nil {
+		return bookmarkError(c, err)
+	}
+
+	// Find the collections
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := collectionsCollection.Find(c.Context(), bson.M{"username": user.Username}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve collections from database",
+		})
+	}
+	collections := []models.BookmarkCollection{}
+	if err := cursor.All(c.Context(), &collections); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve collections from database",
+		})
+	}
+
+	// Count the bookmarks in each collection
+	for i := range collections {
+		count, err := bookmarksCollection.CountDocuments(c.Context(), bson.M{"username": user.Username, "collection": collections[i].Name})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not count bookmarks",
+			})
+		}
+		collections[i].NumberOfBookmarks = count
+	}
+
+	return c.JSON(collections)
+}
+
+// RenameBookmarkCollection renames one of the requesting user's bookmark collections, keeping its bookmarks in it
+func RenameBookmarkCollection(c *fiber.Ctx) error {
+	// Get a handle to the bookmarks and bookmark collections collections
+	bookmarksCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmarks")
+	collectionsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmark_collections")
+
+	// Retrieve the user
+	user, err := findBookmarkOwner(c)
+	if err != nil {
+		return bookmarkError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+	name, err := collectionName(body.Name)
+	if err != nil {
+		return bookmarkError(c, err)
+	}
+
+	// Rename the collection, then move its bookmarks
+	var collection models.BookmarkCollection
+	filter := bson.M{"username": user.Username, "name": collectionParam(c)}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := collectionsCollection.FindOneAndUpdate(c.Context(), filter, bson.M{"$set": bson.M{"name": name}}, opts).Decode(&collection); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return bookmarkError(c, errCollectionDuplicate)
+		}
+		if err == mongo.ErrNoDocuments {
+			return bookmarkError(c, errCollectionNotFound)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update collection in database",
+		})
+	}
+	filter = bson.M{"username": user.Username, "collection": collectionParam(c)}
+	if _, err := bookmarksCollection.UpdateMany(c.Context(), filter, bson.M{"$set": bson.M{"collection": name}}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update bookmarks in database",
+		})
+	}
+
+	return c.JSON(collection)
+}
+
+// DeleteBookmarkCollection removes one of the requesting user's bookmark collections.
+// Its bookmarks are kept, no longer filed in any collection.
+func DeleteBookmarkCollection(c *fiber.Ctx) error {
+	// Get a handle to the bookmarks and bookmark collections collections
+	bookmarksCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmarks")
+	collectionsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("bookmark_collections")
+
+	// Retrieve the user
+	user, err := findBookmarkOwner(c)
+	if err != nil {
+		return bookmarkError(c, err)
+	}
+
+	// Delete the collection, then take its bookmarks out of it
+	res, err := collectionsCollection.DeleteOne(c.Context(), bson.M{"username": user.Username, "name": collectionParam(c)})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete collection from database",
+		})
+	}
+	if res.DeletedCount == 0 {
+		return bookmarkError(c, errCollectionNotFound)
+	}
+	filter := bson.M{"username": user.Username, "collection": collectionParam(c)}
+	if _, err := bookmarksCollection.UpdateMany(c.Context(), filter, bson.M{"$unset": bson.M{"collection": ""}}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update bookmarks in database",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Collection deleted",
+	})
+}
@@ -3,8 +3,9 @@ package routes
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -12,8 +13,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
-	"github.com/alexander-winters/SENG468-A2/kafka-docker/kafkaService"
 	"github.com/alexander-winters/SENG468-A2/mymongo"
 	"github.com/alexander-winters/SENG468-A2/mymongo/models"
 )
@@ -36,11 +37,24 @@ func CreateComment(c *fiber.Ctx) error {
 	}
 
 	// Retrieve the post by username and postNumber
-	post, err := GetPostByUsername(username, postNumber)
+	post, err := findVisiblePost(c, username, postNumber)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "User not found",
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+
+	// Retrieve the user commenting
+	author, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unknown user",
 			})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -56,49 +70,65 @@ func CreateComment(c *fiber.Ctx) error {
 		})
 	}
 
-	// Set the created time
-	comment.CreatedAt = time.Now()
-
-	// Add the comment to the post's comments array
-	post.Comments = append(post.Comments, comment)
-
-	// Update the comment in the database
-	res, err := commentsCollection.InsertOne(c.Context(), comment)
-	if err != nil {
+	// Attribute the comment to the requesting user and set the created time
+	now := time.Now()
+	comment.UserID = author.ID
+	comment.Username = author.Username
+	comment.CreatedAt = now
+	comment.UpdatedAt = now
+	comment.Hashtags, comment.Mentions = parseTags(comment.Content)
+
+	// Start the comment at the top level with no likes, replies or edits; replies go through CreateReply
+	comment.ParentID = primitive.NilObjectID
+	comment.Depth = 0
+	comment.NumberOfReplies = 0
+	comment.DeletedAt = nil
+	comment.NumberOfLikes = 0
+	comment.Likes = []models.Like{}
+	comment.Reactions = nil
+	comment.ReactionCounts = nil
+	comment.Edited = false
+	comment.NumberOfEdits = 0
+
+	// Link the comment to its post
+	comment.ID = primitive.NewObjectID()
+	comment.PostID = post.ID
+	comment.PostNumber = post.PostNumber
+
+	// Insert the comment into the database
+	if _, err := commentsCollection.InsertOne(c.Context(), comment); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not insert comment into database",
 		})
 	}
 
-	// Update the post in the database
-	filter := bson.M{"username": username, "post_number": postNumber}
-	update := bson.M{"$set": bson.M{"comments": post.Comments}}
-	if _, err := postsCollection.UpdateOne(c.Context(), filter, update); err != nil {
+	// Count the comment on the post and keep it among the post's latest comment previews
+	filter := bson.M{"_id": post.ID}
+	update := bson.M{
+		"$inc": bson.M{"number_of_comments": 1},
+		"$push": bson.M{"latest_comments": bson.M{
+			"$each":  []models.Comment{comment},
+			"$slice": -models.LatestCommentsLimit,
+		}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := postsCollection.FindOneAndUpdate(c.Context(), filter, update, opts).Decode(post); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not update post in database",
 		})
 	}
 
 	// Update the post in the Redis cache
-	postJSON, err := json.Marshal(post)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not serialize post to Redis cache",
-		})
-	}
-	if err := rdb.Set(c.Context(), "post:"+username+":"+strconv.Itoa(postNumber), postJSON, 0).Err(); err != nil {
+	if err := cachePost(c.Context(), post); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not set post in Redis cache",
 		})
 	}
 
-	// Set the ID of the comment and return it
-	comment.ID = res.InsertedID.(primitive.ObjectID)
-
 	// Create a Notification
 	notification := models.Notification{
 		UserID:     comment.UserID,
-		Username:   username,
+		Username:   comment.Username,
 		Type:       models.CommentCreatedNotification,
 		PostID:     comment.PostID,
 		CommentID:  comment.ID,
@@ -109,221 +139,317 @@ func CreateComment(c *fiber.Ctx) error {
 		UpdatedAt:  time.Now(),
 	}
 
-	// Initialize Kafka producer and consumer
-	kafkaProducer := kafkaService.CreateKafkaProducer(kafkaBrokerURL)
-	kafkaConsumer := kafkaService.CreateKafkaConsumer(kafkaBrokerURL, "comment")
+	// Send a notification to the post owner
+	sendNotification(notification, "comment")
 
-	ks := kafkaService.NewKafkaService(kafkaProducer, kafkaConsumer)
+	// Notify the users mentioned in the comment
+	notifyMentions(post, models.Notification{
+		UserID:    comment.UserID,
+		Username:  comment.Username,
+		PostID:    comment.PostID,
+		CommentID: comment.ID,
+		Content:   comment.Content,
+	}, comment.Mentions, nil)
+
+	// Count the comment towards the post's trending ranking
+	recordEngagement(c.Context(), post, commentEngagement)
 
-	// Send a notification to the post owner
-	err = ks.SendUserNotification(notification)
-	if err != nil {
-		log.Printf("Could not send notification: %v", err)
-	}
 	return c.JSON(comment)
 }
 
-// GetComment retrieves a comment from the database for a specific post by username and post number
-func GetComment(c *fiber.Ctx) error {
-	// Get handles to the comments and posts collections
+// errInvalidCommentID is returned when the comment_id route parameter is not a valid ObjectID
+var errInvalidCommentID = errors.New("invalid comment ID")
+
+// GetCommentByID retrieves a comment by its ID, first checking Redis cache, then the database
+func GetCommentByID(id primitive.ObjectID) (*models.Comment, error) {
+	// Get a handle to the comments collection
 	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
-	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
 
-	// Get the post number and username from the request parameters
-	username := c.Params("username")
-	postNum, err := strconv.Atoi(c.Params("post_number"))
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid post number",
-		})
-	}
+	// Check Redis cache for the comment
+	ctx := context.Background()
+	commentJSON, err := rdb.Get(ctx, "comment:"+id.Hex()).Result()
 
-	var post models.Post
-	var comment models.Comment
+	if err == redis.Nil {
+		// Comment not found in Redis cache, query the database
+		var comment models.Comment
+		err = commentsCollection.FindOne(ctx, notDeleted(bson.M{"_id": id})).Decode(&comment)
+		if err != nil {
+			return nil, err
+		}
 
-	ctx := c.Context()
-	errChan := make(chan error)
-	done := make(chan bool)
-
-	// Concurrently find the post in the Redis cache or the database by post number and username
-	go func() {
-		cacheKey := "post:" + username + ":" + strconv.Itoa(postNum)
-		if err := rdb.Get(ctx, cacheKey).Scan(&post); err == redis.Nil {
-			err = postsCollection.FindOne(ctx, bson.M{"postNum": postNum, "username": username}).Decode(&post)
-			if err == nil {
-				postJSON, _ := json.Marshal(post)
-				rdb.Set(ctx, cacheKey, postJSON, 0)
-			}
+		// Store the comment in Redis cache
+		if err := cacheComment(ctx, &comment); err != nil {
+			return nil, err
 		}
-		errChan <- err
-		done <- true
-	}()
-
-	// Concurrently find the comment in the Redis cache or the database by post ID
-	go func() {
-		cacheKey := "comment:" + post.ID.Hex()
-		if err := rdb.Get(ctx, cacheKey).Scan(&comment); err == redis.Nil {
-			err = commentsCollection.FindOne(ctx, bson.M{"postID": post.ID}).Decode(&comment)
-			if err == nil {
-				commentJSON, _ := json.Marshal(comment)
-				rdb.Set(ctx, cacheKey, commentJSON, 0)
-			}
+
+		return &comment, nil
+	} else if err != nil {
+		// Redis error occurred
+		return nil, err
+	} else {
+		// Comment found in Redis cache
+		var comment models.Comment
+		err := json.Unmarshal([]byte(commentJSON), &comment)
+		if err != nil {
+			return nil, err
 		}
-		errChan <- err
-		done <- true
-	}()
-
-	for i := 0; i < 2; i++ {
-		select {
-		case err = <-errChan:
-			if err != nil {
-				if err == mongo.ErrNoDocuments {
-					return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-						"error": "Post or comment not found",
-					})
-				}
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "Could not retrieve post or comment from database or cache",
-				})
-			}
-		case <-done:
+		if comment.DeletedAt != nil {
+			return nil, mongo.ErrNoDocuments
 		}
+		return &comment, nil
 	}
+}
 
-	return c.JSON(comment)
+// cacheComment stores a comment in the Redis cache under its ID
+func cacheComment(ctx context.Context, comment *models.Comment) error {
+	commentJSONBytes, err := json.Marshal(comment)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, "comment:"+comment.ID.Hex(), string(commentJSONBytes), 0).Err()
 }
 
-// UpdateComment updates a comment in the database by username and post number
-func UpdateComment(c *fiber.Ctx) error {
-	// Get the username and post number from the request parameters
-	username := c.Params("username")
-	postNumber, err := strconv.Atoi(c.Params("postNumber"))
+// findRequestedComment retrieves the comment addressed by the comment_id route parameter.
+// On routes nested under a post, the comment must belong to the post given by the username and post_number parameters.
+func findRequestedComment(c *fiber.Ctx) (*models.Comment, error) {
+	commentID, err := primitive.ObjectIDFromHex(c.Params("comment_id"))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid post number",
-		})
+		return nil, errInvalidCommentID
 	}
 
-	// Retrieve the post
-	post, err := GetPostByUsername(username, postNumber)
+	comment, err := GetCommentByID(commentID)
 	if err != nil {
+		return nil, err
+	}
+
+	// Check the comment belongs to the post in the path, if any, and that the requesting user may see the post
+	if username := c.Params("username"); username != "" {
+		postNumber, err := strconv.Atoi(c.Params("post_number"))
+		if err != nil {
+			return nil, mongo.ErrNoDocuments
+		}
+		post, err := findVisiblePost(c, username, postNumber)
+		if err != nil {
+			return nil, err
+		}
+		if post.ID != comment.PostID {
+			return nil, mongo.ErrNoDocuments
+		}
+	} else {
+		post, err := findPostByID(c.Context(), comment.PostID)
+		if err != nil {
+			return nil, err
+		}
+		visible, err := canViewPost(post, currentUsername(c))
+		if err != nil {
+			return nil, err
+		}
+		if !visible {
+			return nil, mongo.ErrNoDocuments
+		}
+	}
+
+	return comment, nil
+}
+
+// commentLookupError writes the response for an error returned by findRequestedComment
+func commentLookupError(c *fiber.Ctx, err error) error {
+	if err == errInvalidCommentID {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid comment ID",
 		})
 	}
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Comment not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve comment",
+	})
+}
 
-	// Get a handle to the comments collection
-	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+// GetComment retrieves a comment by its ID
+func GetComment(c *fiber.Ctx) error {
+	// Retrieve the comment
+	comment, err := findRequestedComment(c)
+	if err != nil {
+		return commentLookupError(c, err)
+	}
 
-	// Parse the request body into a struct
+	return c.JSON(comment)
+}
+
+// UpdateComment updates the content of a comment by its ID, recording the edit as a new revision
+func UpdateComment(c *fiber.Ctx) error {
+	// Retrieve the comment
+	existingComment, err := findRequestedComment(c)
+	if err != nil {
+		return commentLookupError(c, err)
+	}
+	if currentUsername(c) != existingComment.Username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can edit their comments",
+		})
+	}
+
+	// Parse the request body into a struct, the content being the only part of a comment that can be edited
 	var updatedComment models.Comment
 	if err := c.BodyParser(&updatedComment); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Could not parse request body",
 		})
 	}
-
-	// Set the updated time
-	updatedComment.UpdatedAt = time.Now()
-
-	// Find the comment with the given ID
-	var existingComment *models.Comment
-	for _, c := range post.Comments {
-		if c.ID == updatedComment.ID {
-			existingComment = &c
-			break
-		}
-	}
-
-	// Check if the comment was found
-	if existingComment == nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Comment not found",
+	if strings.TrimSpace(updatedComment.Content) == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Content is required",
 		})
 	}
 
-	// Update the comment in the database
-	filter := bson.M{"_id": existingComment.ID}
-	update := bson.M{"$set": bson.M{"content": updatedComment.Content, "updated_at": updatedComment.UpdatedAt}}
-	_, err = commentsCollection.UpdateOne(c.Context(), filter, update)
+	// Get handles to the comments and posts collections
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Update the comment in the database, keeping the previous version to record its revision
+	now := time.Now()
+	hashtags, mentions := parseTags(updatedComment.Content)
+	filter := notDeleted(bson.M{"_id": existingComment.ID})
+	update := bson.M{
+		"$set": bson.M{"content": updatedComment.Content, "hashtags": hashtags, "mentions": mentions, "updated_at": now, "edited": true},
+		"$inc": bson.M{"number_of_edits": 1},
+	}
+	err = commentsCollection.FindOneAndUpdate(c.Context(), filter, update).Decode(existingComment)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Comment not found",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not update comment in database",
 		})
 	}
 
-	// Return the updated comment
-	return c.JSON(updatedComment)
-}
-
-// DeleteComment deletes a comment from the database by username and post number
-func DeleteComment(c *fiber.Ctx) error {
-	// Get the username and post number from the request parameters
-	username := c.Params("username")
-	postNumber, err := strconv.Atoi(c.Params("postNumber"))
+	// Record the revision
+	err = recordRevision(c.Context(), models.CommentRevision, existingComment.ID, existingComment.Content, existingComment.Username, existingComment.CreatedAt, existingComment.NumberOfEdits, updatedComment.Content, existingComment.Username, now)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid post number",
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store revision in database",
 		})
 	}
 
-	// Retrieve the post
-	post, err := GetPostByUsername(username, postNumber)
+	// Notify the users newly mentioned by the edit, who may see the post
+	post, err := findPostByID(c.Context(), existingComment.PostID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid comment ID",
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
 		})
 	}
-
-	// Get a handle to the comments collection
-	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
-
-	// Get the comment ID from the request body
-	var commentToDelete models.Comment
-	if err := c.BodyParser(&commentToDelete); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Could not parse request body",
+	notifyMentions(post, models.Notification{
+		UserID:    existingComment.UserID,
+		Username:  existingComment.Username,
+		PostID:    existingComment.PostID,
+		CommentID: existingComment.ID,
+		Content:   updatedComment.Content,
+	}, mentions, existingComment.Mentions)
+
+	// Apply the edit to the comment
+	existingComment.Content = updatedComment.Content
+	existingComment.Hashtags = hashtags
+	existingComment.Mentions = mentions
+	existingComment.UpdatedAt = now
+	existingComment.Edited = true
+	existingComment.NumberOfEdits++
+
+	// Update the preview of the comment on its post, if it is one of the latest comments
+	filter = bson.M{"_id": existingComment.PostID, "latest_comments._id": existingComment.ID}
+	update = bson.M{"$set": bson.M{
+		"latest_comments.$.content":         existingComment.Content,
+		"latest_comments.$.hashtags":        existingComment.Hashtags,
+		"latest_comments.$.mentions":        existingComment.Mentions,
+		"latest_comments.$.updated_at":      existingComment.UpdatedAt,
+		"latest_comments.$.edited":          true,
+		"latest_comments.$.number_of_edits": existingComment.NumberOfEdits,
+	}}
+	if _, err := postsCollection.UpdateOne(c.Context(), filter, update); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update post in database",
 		})
 	}
 
-	// Find the comment with the given ID
-	var existingComment *models.Comment
-	for _, c := range post.Comments {
-		if c.ID == commentToDelete.ID {
-			existingComment = &c
-			break
-		}
+	// Update the comment in Redis cache and drop the stale post
+	if err := cacheComment(c.Context(), existingComment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store comment in Redis",
+		})
 	}
-
-	// Check if the comment was found
-	if existingComment == nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Comment not found",
+	if err := uncachePostByID(c.Context(), existingComment.PostID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove post from Redis",
 		})
 	}
 
-	// Delete the comment from the database
-	res, err := commentsCollection.DeleteOne(c.Context(), bson.M{"_id": existingComment.ID})
+	// Return the updated comment
+	return c.JSON(existingComment)
+}
+
+// DeleteComment moves a comment, and the replies below it, to the trash by its ID
+func DeleteComment(c *fiber.Ctx) error {
+	// Retrieve the comment
+	existingComment, err := findRequestedComment(c)
 	if err != nil {
+		return commentLookupError(c, err)
+	}
+	if currentUsername(c) != existingComment.Username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can delete their comments",
+		})
+	}
+
+	// Mark the comment and its replies as deleted, uncount them and remove them from Redis cache
+	if err := trashComment(c.Context(), existingComment, time.Now(), bson.M{}); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not delete comment from database",
 		})
-	} else if res.DeletedCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Comment not found",
-		})
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "Comment deleted successfully",
+		"message": "Comment moved to trash",
 	})
 }
 
-// ListComments retrieves all comments for a post by username and post number
+// refreshLatestComments rebuilds the latest comment previews of a post from the comments collection
+func refreshLatestComments(ctx context.Context, postID primitive.ObjectID) error {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Find the latest top-level comments of the post
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(models.LatestCommentsLimit)
+	cursor, err := commentsCollection.Find(ctx, notDeleted(bson.M{"post_id": postID, "parent_id": bson.M{"$exists": false}}), opts)
+	if err != nil {
+		return err
+	}
+	var latest []models.Comment
+	if err := cursor.All(ctx, &latest); err != nil {
+		return err
+	}
+
+	// Store them oldest first, as comments are appended to the previews
+	previews := make([]models.Comment, 0, len(latest))
+	for i := len(latest) - 1; i >= 0; i-- {
+		previews = append(previews, latest[i])
+	}
+	_, err = postsCollection.UpdateOne(ctx, bson.M{"_id": postID}, bson.M{"$set": bson.M{"latest_comments": previews}})
+	return err
+}
+
+// ListComments retrieves all top-level comments for a post by username and post number
 func ListComments(c *fiber.Ctx) error {
 	// Get the username and post number from the request parameters
 	username := c.Params("username")
-	postNumber, err := strconv.Atoi(c.Params("postNumber"))
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid post number",
@@ -331,106 +457,156 @@ func ListComments(c *fiber.Ctx) error {
 	}
 
 	// Retrieve the post
-	post, err := GetPostByUsername(username, postNumber)
+	post, err := findVisiblePost(c, username, postNumber)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid post number",
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
 		})
 	}
 
-	// Return the comments of the post
-	return c.JSON(post.Comments)
-}
-
-func GetCommentByUsername(username string, postNumber int) (*models.Comment, error) {
-	commentsCollection := mymongo.GetMongoClient().Database("seng468_a2_db").Collection("comments")
-	var comment models.Comment
-	err := commentsCollection.FindOne(context.Background(), bson.M{"username": username, "post_number": postNumber}).Decode(&comment)
+	// Find the top-level comments that belong to the post, replies are retrieved through their parent comment
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+	cursor, err := commentsCollection.Find(c.Context(), notDeleted(bson.M{"post_id": post.ID, "parent_id": bson.M{"$exists": false}}))
 	if err != nil {
-		return nil, err
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve comments from database",
+		})
+	}
+
+	comments := []models.Comment{}
+	if err := cursor.All(c.Context(), &comments); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not decode comments from cursor",
+		})
 	}
-	return &comment, nil
+
+	// Return the comments of the post
+	return c.JSON(comments)
 }
 
+// LikeComment adds a like from the requesting user to a comment. Liking an already liked comment returns the comment unchanged.
 func LikeComment(c *fiber.Ctx) error {
-	// Get the username and post number from the request parameters
-	username := c.Params("username")
-	postNumber, err := strconv.Atoi(c.Params("postNumber"))
+	// Retrieve the user liking the comment
+	liker, err := GetUserByUsername(currentUsername(c))
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid post number",
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unknown user",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
 		})
 	}
 
 	// Get a handle to the comments collection
-	commentsCollection := mymongo.GetMongoClient().Database("seng468_a2_db").Collection("comments")
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
 
 	// Retrieve the existing comment
-	existingComment, err := GetCommentByUsername(username, postNumber)
+	existingComment, err := findRequestedComment(c)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "Comment not found",
-			})
-		}
+		return commentLookupError(c, err)
+	}
+
+	// Add the like only if the user has not already liked the comment
+	like := models.Like{
+		Username: liker.Username,
+		LikedAt:  time.Now(),
+	}
+	filter := bson.M{"_id": existingComment.ID, "likes.username": bson.M{"$ne": liker.Username}}
+	update := bson.M{
+		"$push": bson.M{"likes": like},
+		"$inc":  bson.M{"number_of_likes": 1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var comment models.Comment
+	err = commentsCollection.FindOneAndUpdate(c.Context(), filter, update, opts).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		// The comment is already liked, return its current state
+		return c.JSON(existingComment)
+	} else if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not retrieve comment",
+			"error": "Could not update comment in database",
 		})
 	}
 
-	// Check if the user has already liked the comment
-	liked := false
-	for _, like := range existingComment.Likes {
-		if like.Username == c.Locals("username").(string) {
-			liked = true
-			break
-		}
+	// Update the comment in Redis cache
+	if err := cacheComment(c.Context(), &comment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store comment in Redis",
+		})
 	}
 
-	// Add the like and increment the likes count if the user has not already liked the comment
-	if !liked {
-		like := models.Like{
-			Username: c.Locals("username").(string),
-			LikedAt:  time.Now(),
-		}
-		existingComment.Likes = append(existingComment.Likes, like)
-		existingComment.NumberOfLikes = existingComment.NumberOfLikes + 1
-	}
-	// Create a Notification
+	// Notify the comment owner of the new like
 	notification := models.Notification{
-		UserID:     existingComment.UserID,
-		Username:   username,
-		Type:       models.PostCreatedNotification,
-		PostID:     existingComment.ID,
-		Recipient:  existingComment.Username,
-		Content:    existingComment.Content,
+		UserID:     liker.ID,
+		Username:   liker.Username,
+		Type:       models.CommentLikedNotification,
+		PostID:     comment.PostID,
+		CommentID:  comment.ID,
+		Recipient:  comment.Username,
+		Content:    comment.Content,
 		ReadStatus: false,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
+	sendNotification(notification, "like")
+
+	// Return the updated comment
+	return c.JSON(comment)
+}
 
-	// Initialize Kafka producer and consumer
-	kafkaProducer := kafkaService.CreateKafkaProducer(kafkaBrokerURL)
-	kafkaConsumer := kafkaService.CreateKafkaConsumer(kafkaBrokerURL, "like")
+// UnlikeComment removes the requesting user's like from a comment. Unliking a comment that is not liked returns the comment unchanged.
+func UnlikeComment(c *fiber.Ctx) error {
+	// Get the user removing the like
+	liker := currentUsername(c)
+	if liker == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown user",
+		})
+	}
 
-	ks := kafkaService.NewKafkaService(kafkaProducer, kafkaConsumer)
+	// Get a handle to the comments collection
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
 
-	// Send a notification to the post owner
-	err = ks.SendUserNotification(notification)
+	// Retrieve the existing comment
+	existingComment, err := findRequestedComment(c)
 	if err != nil {
-		log.Printf("Could not send notification: %v", err)
+		return commentLookupError(c, err)
 	}
 
-	// Update the post in the database
-	filter := bson.M{"username": username, "post_number": postNumber}
-	update := bson.M{"$set": bson.M{"likes": existingComment.Likes, "likes_count": existingComment.NumberOfLikes}}
-	_, err = commentsCollection.UpdateOne(c.Context(), filter, update)
-	if err != nil {
+	// Remove the like only if the user has liked the comment
+	filter := bson.M{"_id": existingComment.ID, "likes.username": liker}
+	update := bson.M{
+		"$pull": bson.M{"likes": bson.M{"username": liker}},
+		"$inc":  bson.M{"number_of_likes": -1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var comment models.Comment
+	err = commentsCollection.FindOneAndUpdate(c.Context(), filter, update, opts).Decode(&comment)
+	if err == mongo.ErrNoDocuments {
+		// The comment is not liked, return its current state
+		return c.JSON(existingComment)
+	} else if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not update post in database",
+			"error": "Could not update comment in database",
 		})
 	}
 
-	// Return the updated post
-	return c.JSON(existingComment)
+	// Update the comment in Redis cache
+	if err := cacheComment(c.Context(), &comment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store comment in Redis",
+		})
+	}
+
+	// Return the updated comment
+	return c.JSON(comment)
 }
@@ -0,0 +1,375 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// postSchedulerInterval is how often scheduled posts that are due are published, configured through POST_SCHEDULER_INTERVAL
+var postSchedulerInterval = durationFromEnv("POST_SCHEDULER_INTERVAL", time.Minute)
+
+// errInvalidDraftID is returned when the draft_id route parameter is not a valid ObjectID
+var errInvalidDraftID = errors.New("invalid draft ID")
+
+// errNotDraftAuthor is returned when a draft is requested by someone other than its author
+var errNotDraftAuthor = errors.New("not the draft author")
+
+// Errors returned by parseDraft for a request body that is not a valid draft
+var (
+	errDraftBody       = errors.New("could not parse draft")
+	errDraftVisibility = errors.New("invalid draft visibility")
+	errDraftPublishAt  = errors.New("draft publish time is not in the future")
+)
+
+// findRequestedAuthor retrieves the user named by the username route parameter, who must be the requesting user
+func findRequestedAuthor(c *fiber.Ctx) (*models.User, error) {
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return nil, errNotDraftAuthor
+	}
+	return GetUserByUsername(username)
+}
+
+// draftFilter matches the draft named by the draft_id route parameter, belonging to the given author
+func draftFilter(c *fiber.Ctx, author *models.User) (bson.M, error) {
+	draftID, err := primitive.ObjectIDFromHex(c.Params("draft_id"))
+	if err != nil {
+		return nil, errInvalidDraftID
+	}
+	return bson.M{"_id": draftID, "username": author.Username}, nil
+}
+
+// draftLookupError writes the response for an error returned while reading a draft, or retrieving it or its author
+func draftLookupError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errDraftBody:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	case errDraftVisibility:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid visibility",
+		})
+	case errDraftPublishAt:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Publish time must be in the future",
+		})
+	case errPollOptions, errPollClosesAt, errPollResultsVisibility:
+		return pollError(c, err)
+	case errNotDraftAuthor:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Drafts are only available to their author",
+		})
+	case errInvalidDraftID:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid draft ID",
+		})
+	case mongo.ErrNoDocuments:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Draft not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve draft",
+	})
+}
+
+// parseDraft reads a draft from the request body.
+// A draft with a publish time is scheduled, which must be in the future.
+func parseDraft(c *fiber.Ctx) (*models.Post, error) {
+	var draft models.Post
+	if err := c.BodyParser(&draft); err != nil {
+		return nil, errDraftBody
+	}
+
+	draft.GroupID = primitive.NilObjectID
+	if draft.Visibility == "" {
+		draft.Visibility = models.PublicVisibility
+	}
+	if !isVisibility(draft.Visibility) {
+		return nil, errDraftVisibility
+	}
+
+	draft.Status = models.DraftPost
+	opensAt := time.Now()
+	if draft.PublishAt != nil {
+		if !draft.PublishAt.After(opensAt) {
+			return nil, errDraftPublishAt
+		}
+		draft.Status = models.ScheduledPost
+		opensAt = *draft.PublishAt
+	}
+
+	// A poll must stay open for a while after the post is published
+	if draft.Poll != nil {
+		if err := preparePoll(draft.Poll, opensAt); err != nil {
+			return nil, err
+		}
+	}
+	return &draft, nil
+}
+
+// CreateDraft saves a post as a draft, or schedules it if it has a publish time.
+// Drafts are kept apart from published posts and only get a post number once published.
+func CreateDraft(c *fiber.Ctx) error {
+	// Get a handle to the drafts collection
+	draftsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("drafts")
+
+	// Retrieve the author
+	author, err := findRequestedAuthor(c)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+
+	// Parse the request body into a draft
+	draft, err := parseDraft(c)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+
+	// Insert the draft into the database
+	now := time.Now()
+	draft.ID = primitive.NewObjectID()
+	draft.UserID = author.ID
+	draft.Username = author.Username
+	draft.CreatedAt = now
+	draft.UpdatedAt = now
+	if _, err := draftsCollection.InsertOne(c.Context(), draft); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert draft into database",
+		})
+	}
+
+	return c.JSON(draft)
+}
+
+// ListDrafts retrieves the drafts and scheduled posts of the requesting user, soonest scheduled first
+func ListDrafts(c *fiber.Ctx) error {
+	// Get a handle to the drafts collection
+	draftsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("drafts")
+
+	// Retrieve the author
+	author, err := findRequestedAuthor(c)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+
+	// Find the author's drafts
+	opts := options.Find().SetSort(bson.D{{Key: "publish_at", Value: 1}, {Key: "updated_at", Value: -1}})
+	cursor, err := draftsCollection.Find(c.Context(), bson.M{"username": author.Username}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve drafts from database",
+		})
+	}
+	drafts := []models.Post{}
+	if err := cursor.All(c.Context(), &drafts); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve drafts from database",
+		})
+	}
+
+	return c.JSON(drafts)
+}
+
+// GetDraft retrieves a draft of the requesting user
+func GetDraft(c *fiber.Ctx) error {
+	// Get a handle to the drafts collection
+	draftsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("drafts")
+
+	// Retrieve the author
+	author, err := findRequestedAuthor(c)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+	filter, err := draftFilter(c, author)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+
+	// Retrieve the draft
+	var draft models.Post
+	if err := draftsCollection.FindOne(c.Context(), filter).Decode(&draft); err != nil {
+		return draftLookupError(c, err)
+	}
+
+	return c.JSON(draft)
+}
+
+// UpdateDraft replaces the content, visibility, poll and publish time of a draft.
+// Giving a publish time schedules the draft, leaving it out turns a scheduled post back into a draft.
+func UpdateDraft(c *fiber.Ctx) error {
+	// Get a handle to the drafts collection
+	draftsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("drafts")
+
+	// Retrieve the author
+	author, err := findRequestedAuthor(c)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+	filter, err := draftFilter(c, author)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+
+	// Parse the request body into a draft
+	draft, err := parseDraft(c)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+
+	// Update the draft in the database
+	update := bson.M{"$set": bson.M{
+		"content":    draft.Content,
+		"visibility": draft.Visibility,
+		"status":     draft.Status,
+		"publish_at": draft.PublishAt,
+		"poll":       draft.Poll,
+		"updated_at": time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := draftsCollection.FindOneAndUpdate(c.Context(), filter, update, opts).Decode(draft); err != nil {
+		return draftLookupError(c, err)
+	}
+
+	return c.JSON(draft)
+}
+
+// DeleteDraft permanently removes a draft of the requesting user
+func DeleteDraft(c *fiber.Ctx) error {
+	// Get a handle to the drafts collection
+	draftsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("drafts")
+
+	// Retrieve the author
+	author, err := findRequestedAuthor(c)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+	filter, err := draftFilter(c, author)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+
+	// Delete the draft
+	res, err := draftsCollection.DeleteOne(c.Context(), filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete draft from database",
+		})
+	}
+	if res.DeletedCount == 0 {
+		return draftLookupError(c, mongo.ErrNoDocuments)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Draft deleted",
+	})
+}
+
+// publishDraft moves a draft matching filter to the published posts.
+// The draft is claimed by removing it, so that it is published only once, and put back as it was if publishing fails.
+func publishDraft(ctx context.Context, filter bson.M) (*models.Post, error) {
+	draftsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("drafts")
+
+	var draft models.Post
+	if err := draftsCollection.FindOneAndDelete(ctx, filter).Decode(&draft); err != nil {
+		return nil, err
+	}
+
+	// publishPost fills in the post it is given, so it publishes a copy of the draft
+	post := draft
+	author, err := GetUserByUsername(draft.Username)
+	if err == nil {
+		err = publishPost(ctx, author, &post)
+	}
+	if err != nil {
+		if _, insertErr := draftsCollection.InsertOne(ctx, draft); insertErr != nil {
+			log.Printf("Could not restore draft %s: %v", draft.ID.Hex(), insertErr)
+		}
+		return nil, err
+	}
+	return &post, nil
+}
+
+// PublishDraft publishes a draft or scheduled post of the requesting user straight away
+func PublishDraft(c *fiber.Ctx) error {
+	// Retrieve the author
+	author, err := findRequestedAuthor(c)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+	filter, err := draftFilter(c, author)
+	if err != nil {
+		return draftLookupError(c, err)
+	}
+
+	// Publish the draft
+	post, err := publishDraft(c.Context(), filter)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return draftLookupError(c, err)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not publish draft",
+		})
+	}
+
+	return c.JSON(post)
+}
+
+// publishDuePosts publishes the scheduled posts whose publish time has passed
+func publishDuePosts(ctx context.Context) error {
+	// Only one web server publishes per interval
+	acquired, err := acquireLock(ctx, "post-scheduler", postSchedulerInterval/2)
+	if err != nil || !acquired {
+		return err
+	}
+
+	// Find the posts that are due, each of which is only published if it is still scheduled
+	draftsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("drafts")
+	ids, err := objectIDs(ctx, draftsCollection, bson.M{"status": models.ScheduledPost, "publish_at": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return err
+	}
+
+	published := 0
+	for _, id := range ids {
+		if _, err := publishDraft(ctx, bson.M{"_id": id, "status": models.ScheduledPost}); err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Could not publish scheduled post %s: %v", id.Hex(), err)
+			}
+			continue
+		}
+		published++
+	}
+
+	if published > 0 {
+		log.Printf("Published %d scheduled posts", published)
+	}
+	return nil
+}
+
+// StartPostScheduler periodically publishes scheduled posts that are due in the background
+func StartPostScheduler() {
+	go func() {
+		ticker := time.NewTicker(postSchedulerInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := publishDuePosts(context.Background()); err != nil {
+				log.Printf("Could not publish scheduled posts: %v", err)
+			}
+		}
+	}()
+}
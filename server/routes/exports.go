@@ -0,0 +1,446 @@
+package routes
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+	"github.com/alexander-winters/SENG468-A2/storage"
+)
+
+// exportStore holds finished export archives, on local disk below EXPORT_DIR
+var exportStore storage.Store = storage.NewLocalStore(exportDir())
+
+// exportRetention is how long a finished export can be downloaded, configured through EXPORT_RETENTION
+var exportRetention = durationFromEnv("EXPORT_RETENTION", 24*time.Hour)
+
+// exportCleanupInterval is how often expired exports are removed, configured through EXPORT_CLEANUP_INTERVAL
+var exportCleanupInterval = durationFromEnv("EXPORT_CLEANUP_INTERVAL", time.Hour)
+
+// exportDir reads the directory export archives are stored in, defaulting to "exports"
+func exportDir() string {
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return "exports"
+}
+
+// dataExport is an export archive being written for a user
+type dataExport struct {
+	user    *models.User
+	archive *zip.Writer
+}
+
+// dataExportStep writes one part of an export archive
+type dataExportStep struct {
+	name string
+	run  func(ctx context.Context, e *dataExport) error
+}
+
+// dataExportSteps are the steps of exporting a user's data, in the order they run, followed by storing the archive
+var dataExportSteps = []dataExportStep{
+	{"profile", exportProfile},
+	{"posts", exportPosts},
+	{"comments", exportComments},
+	{"likes", exportLikes},
+	{"notifications", exportNotifications},
+}
+
+// writeJSON adds a JSON file to the archive
+func (e *dataExport) writeJSON(name string, v interface{}) error {
+	w, err := e.archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// writeCSV adds a CSV file with the given header row to the archive
+func (e *dataExport) writeCSV(name string, header []string, rows [][]string) error {
+	w, err := e.archive.Create(name)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// formatTime formats an optional time for a CSV file, leaving it blank if unset
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// exportProfile writes the user's profile, leaving out their password
+func exportProfile(ctx context.Context, e *dataExport) error {
+	return e.writeJSON("profile.json", fiber.Map{
+		"username":        e.user.Username,
+		"first_name":      e.user.FirstName,
+		"last_name":       e.user.LastName,
+		"bio":             e.user.Bio,
+		"location":        e.user.Location,
+		"website":         e.user.Website,
+		"email":           e.user.Email,
+		"date_of_birth":   e.user.DateOfBirth,
+		"list_of_friends": e.user.ListOfFriends,
+		"post_count":      e.user.PostCount,
+		"created_at":      e.user.CreatedAt,
+		"updated_at":      e.user.UpdatedAt,
+	})
+}
+
+// exportPosts writes the user's posts, including those in the trash
+func exportPosts(ctx context.Context, e *dataExport) error {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	cursor, err := postsCollection.Find(ctx, bson.M{"username": e.user.Username})
+	if err != nil {
+		return err
+	}
+	posts := []models.Post{}
+	if err := cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+	if err := e.writeJSON("posts.json", posts); err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(posts))
+	for _, post := range posts {
+		rows = append(rows, []string{
+			post.ID.Hex(),
+			strconv.Itoa(post.PostNumber),
+			post.Content,
+			formatTime(&post.CreatedAt),
+			formatTime(&post.UpdatedAt),
+			formatTime(post.DeletedAt),
+			strconv.Itoa(post.NumberOfLikes),
+			strconv.Itoa(post.NumberOfComments),
+		})
+	}
+	header := []string{"id", "post_number", "content", "created_at", "updated_at", "deleted_at", "number_of_likes", "number_of_comments"}
+	return e.writeCSV("posts.csv", header, rows)
+}
+
+// exportComments writes the user's comments, including those in the trash
+func exportComments(ctx context.Context, e *dataExport) error {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	cursor, err := commentsCollection.Find(ctx, bson.M{"username": e.user.Username})
+	if err != nil {
+		return err
+	}
+	comments := []models.Comment{}
+	if err := cursor.All(ctx, &comments); err != nil {
+		return err
+	}
+	if err := e.writeJSON("comments.json", comments); err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(comments))
+	for _, comment := range comments {
+		parentID := ""
+		if !comment.ParentID.IsZero() {
+			parentID = comment.ParentID.Hex()
+		}
+		rows = append(rows, []string{
+			comment.ID.Hex(),
+			comment.PostID.Hex(),
+			strconv.Itoa(comment.PostNumber),
+			parentID,
+			comment.Content,
+			formatTime(&comment.CreatedAt),
+			formatTime(&comment.UpdatedAt),
+			formatTime(comment.DeletedAt),
+			strconv.Itoa(comment.NumberOfLikes),
+		})
+	}
+	header := []string{"id", "post_id", "post_number", "parent_id", "content", "created_at", "updated_at", "deleted_at", "number_of_likes"}
+	return e.writeCSV("comments.csv", header, rows)
+}
+
+// exportedLike is a like the user gave to a post or comment
+type exportedLike struct {
+	TargetType string    `json:"target_type"`
+	TargetID   string    `json:"target_id"`
+	Author     string    `json:"author"`
+	PostNumber int       `json:"post_number"`
+	LikedAt    time.Time `json:"liked_at"`
+}
+
+// exportLikes writes the likes the user gave to posts and comments
+func exportLikes(ctx context.Context, e *dataExport) error {
+	database := mymongo.GetMongoClient().Database("seng468-a2-db")
+
+	// Only the user's own like is loaded from each document
+	filter := bson.M{"likes.username": e.user.Username}
+	projection := bson.M{
+		"username":    1,
+		"post_number": 1,
+		"likes":       bson.M{"$elemMatch": bson.M{"username": e.user.Username}},
+	}
+
+	likes := []exportedLike{}
+	for _, target := range []string{"post", "comment"} {
+		cursor, err := database.Collection(target+"s").Find(ctx, filter, options.Find().SetProjection(projection))
+		if err != nil {
+			return err
+		}
+		var docs []models.Post
+		if err := cursor.All(ctx, &docs); err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			if len(doc.Likes) == 0 {
+				continue
+			}
+			likes = append(likes, exportedLike{
+				TargetType: target,
+				TargetID:   doc.ID.Hex(),
+				Author:     doc.Username,
+				PostNumber: doc.PostNumber,
+				LikedAt:    doc.Likes[0].LikedAt,
+			})
+		}
+	}
+	if err := e.writeJSON("likes.json", likes); err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(likes))
+	for _, like := range likes {
+		rows = append(rows, []string{like.TargetType, like.TargetID, like.Author, strconv.Itoa(like.PostNumber), formatTime(&like.LikedAt)})
+	}
+	return e.writeCSV("likes.csv", []string{"target_type", "target_id", "author", "post_number", "liked_at"}, rows)
+}
+
+// exportNotifications writes the notifications sent to the user
+func exportNotifications(ctx context.Context, e *dataExport) error {
+	notificationsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("notifications")
+
+	cursor, err := notificationsCollection.Find(ctx, bson.M{"recipient": e.user.Username})
+	if err != nil {
+		return err
+	}
+	notifications := []models.Notification{}
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return err
+	}
+	if err := e.writeJSON("notifications.json", notifications); err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(notifications))
+	for _, notification := range notifications {
+		rows = append(rows, []string{
+			notification.ID.Hex(),
+			string(notification.Type),
+			notification.Username,
+			notification.PostID.Hex(),
+			notification.CommentID.Hex(),
+			notification.Content,
+			strconv.FormatBool(notification.ReadStatus),
+			formatTime(&notification.CreatedAt),
+		})
+	}
+	header := []string{"id", "type", "username", "post_id", "comment_id", "content", "read_status", "created_at"}
+	return e.writeCSV("notifications.csv", header, rows)
+}
+
+// exportData writes a zip archive of a user's data to the export store under key.
+// progress, if not nil, is called before each step with the number of steps completed so far.
+func exportData(ctx context.Context, user *models.User, key string, progress func(step string, completed int)) error {
+	var buf bytes.Buffer
+	e := &dataExport{user: user, archive: zip.NewWriter(&buf)}
+
+	for i, step := range dataExportSteps {
+		if progress != nil {
+			progress(step.name, i)
+		}
+		if err := step.run(ctx, e); err != nil {
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+	}
+
+	if progress != nil {
+		progress("archive", len(dataExportSteps))
+	}
+	if err := e.archive.Close(); err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+	if err := exportStore.Put(ctx, key, &buf); err != nil {
+		return fmt.Errorf("archive: %w", err)
+	}
+	return nil
+}
+
+// purgeExpiredExports removes the export archives that can no longer be downloaded
+func purgeExpiredExports(ctx context.Context) error {
+	jobsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("jobs")
+
+	// Only one web server cleans up per interval
+	acquired, err := acquireLock(ctx, "export-cleanup", exportCleanupInterval/2)
+	if err != nil || !acquired {
+		return err
+	}
+
+	filter := bson.M{
+		"type":       models.DataExportJob,
+		"result_key": bson.M{"$exists": true},
+		"expires_at": bson.M{"$lt": time.Now()},
+	}
+	cursor, err := jobsCollection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var jobs []models.Job
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := exportStore.Delete(ctx, job.ResultKey); err != nil {
+			return err
+		}
+		if _, err := jobsCollection.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$unset": bson.M{"result_key": ""}}); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Removed %d expired data exports", len(jobs))
+	return nil
+}
+
+// StartExportCleanup periodically removes expired export archives in the background
+func StartExportCleanup() {
+	go func() {
+		ticker := time.NewTicker(exportCleanupInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := purgeExpiredExports(context.Background()); err != nil {
+				log.Printf("Could not remove expired exports: %v", err)
+			}
+		}
+	}()
+}
+
+// StartDataExport starts a background job that exports a user's data into a zip archive of JSON and CSV files
+func StartDataExport(c *fiber.Ctx) error {
+	// Retrieve the user, who can only export their own data
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only export their own data",
+		})
+	}
+	user, err := GetUserByUsername(username)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+
+	// Create the job that tracks the export
+	job, err := createJob(c.Context(), models.DataExportJob, user.Username, len(dataExportSteps)+1)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not create data export job",
+		})
+	}
+
+	// Export the data in the background
+	go func() {
+		ctx := context.Background()
+		key := fmt.Sprintf("%s/%s.zip", user.Username, job.ID.Hex())
+		err := exportData(ctx, user, key, func(step string, completed int) {
+			updateJobProgress(ctx, job.ID, step, completed)
+		})
+
+		var extra bson.M
+		if err != nil {
+			log.Printf("Could not export data of %s: %v", user.Username, err)
+		} else {
+			extra = bson.M{"result_key": key, "expires_at": time.Now().Add(exportRetention)}
+		}
+		finishJob(ctx, job, err, extra)
+	}()
+
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// DownloadDataExport downloads the archive of a completed data export job until it expires
+func DownloadDataExport(c *fiber.Ctx) error {
+	// Retrieve the job
+	job, err := findRequestedJob(c)
+	if err != nil {
+		return jobLookupError(c, err)
+	}
+	if job.Type != models.DataExportJob {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job has nothing to download",
+		})
+	}
+
+	// Check the archive is ready and has not expired
+	switch {
+	case job.Status == models.JobFailed:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Export failed",
+		})
+	case job.Status != models.JobCompleted:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Export is not ready yet",
+		})
+	case job.ResultKey == "" || job.ExpiresAt == nil || time.Now().After(*job.ExpiresAt):
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "Export has expired",
+		})
+	}
+
+	// Stream the archive from the export store
+	archive, err := exportStore.Get(c.Context(), job.ResultKey)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return c.Status(fiber.StatusGone).JSON(fiber.Map{
+				"error": "Export has expired",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve export",
+		})
+	}
+
+	c.Attachment(fmt.Sprintf("%s-export.zip", job.Username))
+	return c.SendStream(archive)
+}
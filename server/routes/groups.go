@@ -0,0 +1,980 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// Limits on the length, in characters, of a group's name and description
+const (
+	maxGroupNameLength        = 100
+	maxGroupDescriptionLength = 1000
+)
+
+// Errors returned while finding or changing groups and their members
+var (
+	errGroupNotFound      = errors.New("group not found")
+	errUnknownGroupUser   = errors.New("unknown user")
+	errGroupName          = errors.New("invalid group name")
+	errGroupDescription   = errors.New("group description too long")
+	errGroupPrivacy       = errors.New("invalid group privacy")
+	errGroupRole          = errors.New("invalid group role")
+	errNotGroupMember     = errors.New("not a group member")
+	errNotGroupModerator  = errors.New("not a group moderator")
+	errNotGroupOwner      = errors.New("not the group owner")
+	errAlreadyGroupMember = errors.New("already a group member")
+	errMembershipNotFound = errors.New("membership not found")
+	errOwnerLeaving       = errors.New("owner cannot leave group")
+)
+
+// groupError writes the response for an error returned while finding or changing groups and their members
+func groupError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errGroupNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Group not found",
+		})
+	case errUnknownGroupUser:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown user",
+		})
+	case errGroupName:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Group names must be 1 to %d characters", maxGroupNameLength),
+		})
+	case errGroupDescription:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Group descriptions can be at most %d characters", maxGroupDescriptionLength),
+		})
+	case errGroupPrivacy:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Privacy must be public or private",
+		})
+	case errGroupRole:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Role must be owner, moderator or member",
+		})
+	case errNotGroupMember:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only members of the group can do this",
+		})
+	case errNotGroupModerator:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only moderators of the group can do this",
+		})
+	case errNotGroupOwner:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the owner of the group can do this",
+		})
+	case errAlreadyGroupMember:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "User is already a member of the group",
+		})
+	case errMembershipNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Membership not found",
+		})
+	case errOwnerLeaving:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "The owner must hand the group over or delete it before leaving",
+		})
+	case mongo.ErrNoDocuments:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve group",
+	})
+}
+
+// groupsOf returns the private groups viewer is an active member of, whose posts viewer may see
+func groupsOf(ctx context.Context, viewer string) ([]primitive.ObjectID, error) {
+	if viewer == "" {
+		return nil, nil
+	}
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	filter := bson.M{"username": viewer, "status": models.ActiveMembership}
+	cursor, err := membershipsCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"group_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var memberships []models.GroupMembership
+	if err := cursor.All(ctx, &memberships); err != nil {
+		return nil, err
+	}
+
+	groups := make([]primitive.ObjectID, 0, len(memberships))
+	for _, membership := range memberships {
+		groups = append(groups, membership.GroupID)
+	}
+	return groups, nil
+}
+
+// isGroupMember reports whether username is an active member of a group
+func isGroupMember(ctx context.Context, groupID primitive.ObjectID, username string) (bool, error) {
+	membership, err := findMembership(ctx, groupID, username)
+	if err == errMembershipNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return membership.Status == models.ActiveMembership, nil
+}
+
+// findMembership retrieves a user's membership of a group, whether active or pending
+func findMembership(ctx context.Context, groupID primitive.ObjectID, username string) (*models.GroupMembership, error) {
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	var membership models.GroupMembership
+	if err := membershipsCollection.FindOne(ctx, bson.M{"group_id": groupID, "username": username}).Decode(&membership); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errMembershipNotFound
+		}
+		return nil, err
+	}
+	return &membership, nil
+}
+
+// countGroupMembers sets the number of active members of a group
+func countGroupMembers(ctx context.Context, group *models.Group) error {
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	count, err := membershipsCollection.CountDocuments(ctx, bson.M{"group_id": group.ID, "status": models.ActiveMembership})
+	if err != nil {
+		return err
+	}
+	group.NumberOfMembers = count
+	return nil
+}
+
+// findGroup retrieves the group named by the group_id route parameter
+func findGroup(c *fiber.Ctx) (*models.Group, error) {
+	groupsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("groups")
+
+	id, err := primitive.ObjectIDFromHex(c.Params("group_id"))
+	if err != nil {
+		return nil, errGroupNotFound
+	}
+	var group models.Group
+	if err := groupsCollection.FindOne(c.Context(), bson.M{"_id": id}).Decode(&group); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errGroupNotFound
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+// findGroupRole retrieves the group named by the group_id route parameter along with the requesting user,
+// who must be an active member with at least the given role
+func findGroupRole(c *fiber.Ctx, role models.GroupRole) (*models.Group, *models.User, error) {
+	group, err := findGroup(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errUnknownGroupUser
+		}
+		return nil, nil, err
+	}
+
+	membership, err := findMembership(c.Context(), group.ID, user.Username)
+	if err == errMembershipNotFound || (err == nil && membership.Status != models.ActiveMembership) {
+		return nil, nil, errNotGroupMember
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case role == models.GroupOwner && membership.Role != models.GroupOwner:
+		return nil, nil, errNotGroupOwner
+	case role == models.GroupModerator && membership.Role == models.GroupMember:
+		return nil, nil, errNotGroupModerator
+	}
+	return group, user, nil
+}
+
+// canSeeGroupContent reports whether viewer may see a group's members and posts: anyone for public groups, members only for private ones
+func canSeeGroupContent(ctx context.Context, group *models.Group, viewer string) (bool, error) {
+	if group.Privacy == models.PublicGroup {
+		return true, nil
+	}
+	return isGroupMember(ctx, group.ID, viewer)
+}
+
+// groupPostVisibility is the visibility given to the posts of a group with the given privacy
+func groupPostVisibility(privacy models.GroupPrivacy) models.Visibility {
+	if privacy == models.PrivateGroup {
+		return models.GroupVisibility
+	}
+	return models.PublicVisibility
+}
+
+// validateGroup cleans the name and description of a group and checks its privacy
+func validateGroup(group *models.Group) error {
+	group.Name = strings.TrimSpace(group.Name)
+	group.Description = strings.TrimSpace(group.Description)
+	if group.Name == "" || utf8.RuneCountInString(group.Name) > maxGroupNameLength {
+		return errGroupName
+	}
+	if utf8.RuneCountInString(group.Description) > maxGroupDescriptionLength {
+		return errGroupDescription
+	}
+	if group.Privacy != models.PublicGroup && group.Privacy != models.PrivateGroup {
+		return errGroupPrivacy
+	}
+	return nil
+}
+
+// groupModerators returns the usernames of the owner and moderators of a group
+func groupModerators(ctx context.Context, groupID primitive.ObjectID) ([]string, error) {
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	filter := bson.M{"group_id": groupID, "status": models.ActiveMembership, "role": bson.M{"$in": bson.A{models.GroupOwner, models.GroupModerator}}}
+	usernames, err := membershipsCollection.Distinct(ctx, "username", filter)
+	if err != nil {
+		return nil, err
+	}
+	moderators := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		if name, ok := username.(string); ok {
+			moderators = append(moderators, name)
+		}
+	}
+	return moderators, nil
+}
+
+// groupNotification returns a notification about a group caused by user, naming the group in its content
+func groupNotification(group *models.Group, user *models.User, kind models.NotificationType) models.Notification {
+	return models.Notification{
+		UserID:   user.ID,
+		Username: user.Username,
+		Type:     kind,
+		GroupID:  group.ID,
+		Content:  group.Name,
+	}
+}
+
+// notifyGroup sends a notification to each of the given recipients other than the user who caused it
+func notifyGroup(notification models.Notification, recipients []string) {
+	for _, recipient := range recipients {
+		if recipient == notification.Username {
+			continue
+		}
+		notification.Recipient = recipient
+		notification.ReadStatus = false
+		notification.CreatedAt = time.Now()
+		notification.UpdatedAt = time.Now()
+		sendNotification(notification, "group")
+	}
+}
+
+// notifyGroupPost notifies the active members of a group that a post was published to it
+func notifyGroupPost(ctx context.Context, author *models.User, post *models.Post) error {
+	database := mymongo.GetMongoClient().Database("seng468-a2-db")
+
+	var group models.Group
+	if err := database.Collection("groups").FindOne(ctx, bson.M{"_id": post.GroupID}).Decode(&group); err != nil {
+		return err
+	}
+	usernames, err := database.Collection("group_members").Distinct(ctx, "username", bson.M{"group_id": group.ID, "status": models.ActiveMembership})
+	if err != nil {
+		return err
+	}
+	members := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		if name, ok := username.(string); ok {
+			members = append(members, name)
+		}
+	}
+	notification := groupNotification(&group, author, models.GroupPostNotification)
+	notification.PostID = post.ID
+	notification.Content = post.Content
+	notifyGroup(notification, members)
+	return nil
+}
+
+// activateMembership makes a pending membership active, notifying the group's moderators that the user joined
+func activateMembership(ctx context.Context, group *models.Group, membership *models.GroupMembership, user *models.User) error {
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	joinedAt := time.Now()
+	update := bson.M{"$set": bson.M{"status": models.ActiveMembership, "joined_at": joinedAt}}
+	if _, err := membershipsCollection.UpdateOne(ctx, bson.M{"_id": membership.ID}, update); err != nil {
+		return err
+	}
+	membership.Status = models.ActiveMembership
+	membership.JoinedAt = &joinedAt
+
+	moderators, err := groupModerators(ctx, group.ID)
+	if err != nil {
+		return err
+	}
+	notifyGroup(groupNotification(group, user, models.GroupJoinedNotification), append(moderators, membership.Username))
+	return nil
+}
+
+// deleteGroup removes a group along with its memberships, moving the posts in it to the trash
+func deleteGroup(ctx context.Context, groupID primitive.ObjectID) error {
+	database := mymongo.GetMongoClient().Database("seng468-a2-db")
+
+	if err := trashPosts(ctx, bson.M{"group_id": groupID}, time.Now()); err != nil {
+		return err
+	}
+	if _, err := database.Collection("group_members").DeleteMany(ctx, bson.M{"group_id": groupID}); err != nil {
+		return err
+	}
+	_, err := database.Collection("groups").DeleteOne(ctx, bson.M{"_id": groupID})
+	return err
+}
+
+// CreateGroup creates a group owned by the requesting user
+func CreateGroup(c *fiber.Ctx) error {
+	// Get a handle to the groups and group members collections
+	groupsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("groups")
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	// Retrieve the user
+	user, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return groupError(c, errUnknownGroupUser)
+		}
+		return groupError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var group models.Group
+	if err := c.BodyParser(&group); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+
+	// Groups are public unless made private
+	if group.Privacy == "" {
+		group.Privacy = models.PublicGroup
+	}
+	if err := validateGroup(&group); err != nil {
+		return groupError(c, err)
+	}
+
+	// Insert the group with the user as its owner
+	now := time.Now()
+	group.ID = primitive.NewObjectID()
+	group.Owner = user.Username
+	group.CreatedAt = now
+	group.UpdatedAt = now
+	if _, err := groupsCollection.InsertOne(c.Context(), group); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert group into database",
+		})
+	}
+	membership := models.GroupMembership{
+		ID:        primitive.NewObjectID(),
+		GroupID:   group.ID,
+		Username:  user.Username,
+		Role:      models.GroupOwner,
+		Status:    models.ActiveMembership,
+		CreatedAt: now,
+		JoinedAt:  &now,
+	}
+	if _, err := membershipsCollection.InsertOne(c.Context(), membership); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert group member into database",
+		})
+	}
+
+	group.NumberOfMembers = 1
+	return c.JSON(group)
+}
+
+// ListGroups retrieves a page of groups, newest first, with how many members each has
+func ListGroups(c *fiber.Ctx) error {
+	// Get a handle to the groups collection
+	groupsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("groups")
+
+	// Find the requested page of groups
+	page, limit := pageParams(c)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := groupsCollection.Find(c.Context(), bson.M{}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve groups from database",
+		})
+	}
+	groups := []models.Group{}
+	if err := cursor.All(c.Context(), &groups); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve groups from database",
+		})
+	}
+
+	for i := range groups {
+		if err := countGroupMembers(c.Context(), &groups[i]); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not count group members",
+			})
+		}
+	}
+	return c.JSON(groups)
+}
+
+// GetGroup retrieves a group with how many members it has
+func GetGroup(c *fiber.Ctx) error {
+	group, err := findGroup(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+	if err := countGroupMembers(c.Context(), group); err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(group)
+}
+
+// UpdateGroup changes the name and description of a group, which its moderators may do.
+// Only the owner can change the group's privacy, which also changes who can see the posts in it.
+func UpdateGroup(c *fiber.Ctx) error {
+	// Get a handle to the groups and posts collections
+	groupsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("groups")
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Retrieve the group
+	group, user, err := findGroupRole(c, models.GroupModerator)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	// Parse the request body into a struct, keeping the fields that were not given
+	update := *group
+	if err := c.BodyParser(&update); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+	update.ID, update.Owner, update.CreatedAt = group.ID, group.Owner, group.CreatedAt
+	if err := validateGroup(&update); err != nil {
+		return groupError(c, err)
+	}
+	if update.Privacy != group.Privacy && user.Username != group.Owner {
+		return groupError(c, errNotGroupOwner)
+	}
+
+	// Update the group
+	update.UpdatedAt = time.Now()
+	fields := bson.M{"name": update.Name, "description": update.Description, "privacy": update.Privacy, "updated_at": update.UpdatedAt}
+	if _, err := groupsCollection.UpdateOne(c.Context(), bson.M{"_id": group.ID}, bson.M{"$set": fields}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update group in database",
+		})
+	}
+
+	// Change the visibility of the group's posts along with its privacy
+	if update.Privacy != group.Privacy {
+		cursor, err := postsCollection.Find(c.Context(), bson.M{"group_id": group.ID}, options.Find().SetProjection(bson.M{"username": 1, "post_number": 1}))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not retrieve group posts from database",
+			})
+		}
+		var posts []models.Post
+		if err := cursor.All(c.Context(), &posts); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not retrieve group posts from database",
+			})
+		}
+		visibility := bson.M{"$set": bson.M{"visibility": groupPostVisibility(update.Privacy)}}
+		if _, err := postsCollection.UpdateMany(c.Context(), bson.M{"group_id": group.ID}, visibility); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not update group posts in database",
+			})
+		}
+		for _, post := range posts {
+			if err := rdb.Del(c.Context(), fmt.Sprintf("post:%s:%d", post.Username, post.PostNumber)).Err(); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+					"error": "Could not update post in Redis cache",
+				})
+			}
+		}
+	}
+
+	if err := countGroupMembers(c.Context(), &update); err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(update)
+}
+
+// DeleteGroup removes a group and its memberships, which only its owner may do.
+// The posts in the group are moved to their authors' trash.
+func DeleteGroup(c *fiber.Ctx) error {
+	group, _, err := findGroupRole(c, models.GroupOwner)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	if err := deleteGroup(c.Context(), group.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete group from database",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Group deleted",
+	})
+}
+
+// JoinGroup adds the requesting user to a group. Public groups can be joined straight away,
+// while joining a private group sends a request for its moderators to approve, unless the user was invited.
+func JoinGroup(c *fiber.Ctx) error {
+	// Get a handle to the group members collection
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	// Retrieve the group and the user
+	group, err := findGroup(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+	user, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return groupError(c, errUnknownGroupUser)
+		}
+		return groupError(c, err)
+	}
+
+	// Accept an invitation the user was sent, or leave an existing membership or request as it is
+	membership, err := findMembership(c.Context(), group.ID, user.Username)
+	if err != nil && err != errMembershipNotFound {
+		return groupError(c, err)
+	}
+	if err == nil {
+		if membership.Status == models.InvitedMembership {
+			if err := activateMembership(c.Context(), group, membership, user); err != nil {
+				return groupError(c, err)
+			}
+		}
+		return c.JSON(membership)
+	}
+
+	// Join a public group, or ask to join a private one
+	membership = &models.GroupMembership{
+		ID:        primitive.NewObjectID(),
+		GroupID:   group.ID,
+		Username:  user.Username,
+		Role:      models.GroupMember,
+		Status:    models.RequestedMembership,
+		CreatedAt: time.Now(),
+	}
+	if group.Privacy == models.PublicGroup {
+		membership.Status = models.ActiveMembership
+		membership.JoinedAt = &membership.CreatedAt
+	}
+	if _, err := membershipsCollection.InsertOne(c.Context(), membership); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return groupError(c, errAlreadyGroupMember)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert group member into database",
+		})
+	}
+
+	// Let the moderators know someone joined, or is waiting for their approval
+	moderators, err := groupModerators(c.Context(), group.ID)
+	if err != nil {
+		return groupError(c, err)
+	}
+	kind := models.GroupJoinedNotification
+	if membership.Status == models.RequestedMembership {
+		kind = models.GroupRequestNotification
+	}
+	notifyGroup(groupNotification(group, user, kind), moderators)
+
+	return c.JSON(membership)
+}
+
+// LeaveGroup removes the requesting user from a group, or withdraws their request to join it.
+// The owner cannot leave the group they own.
+func LeaveGroup(c *fiber.Ctx) error {
+	// Get a handle to the group members collection
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	// Retrieve the group and the membership
+	group, err := findGroup(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+	membership, err := findMembership(c.Context(), group.ID, currentUsername(c))
+	if err != nil {
+		return groupError(c, err)
+	}
+	if membership.Role == models.GroupOwner {
+		return groupError(c, errOwnerLeaving)
+	}
+
+	// Delete the membership
+	if _, err := membershipsCollection.DeleteOne(c.Context(), bson.M{"_id": membership.ID}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete group member from database",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Left group",
+	})
+}
+
+// InviteToGroup invites the user in the request body to a group. Any member can invite others to a public group,
+// while only moderators can invite users to a private one. Inviting a user who asked to join approves them.
+func InviteToGroup(c *fiber.Ctx) error {
+	// Get a handle to the group members collection
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	// Retrieve the group, making sure the requesting user may invite others to it
+	group, err := findGroup(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+	role := models.GroupMember
+	if group.Privacy == models.PrivateGroup {
+		role = models.GroupModerator
+	}
+	group, inviter, err := findGroupRole(c, role)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	// Parse the request body into a struct and retrieve the invited user
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+	invitee, err := GetUserByUsername(body.Username)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	// Approve a request to join, or leave an existing membership or invitation as it is
+	membership, err := findMembership(c.Context(), group.ID, invitee.Username)
+	if err != nil && err != errMembershipNotFound {
+		return groupError(c, err)
+	}
+	if err == nil {
+		switch membership.Status {
+		case models.ActiveMembership:
+			return groupError(c, errAlreadyGroupMember)
+		case models.RequestedMembership:
+			if err := activateMembership(c.Context(), group, membership, inviter); err != nil {
+				return groupError(c, err)
+			}
+		}
+		return c.JSON(membership)
+	}
+
+	// Invite the user
+	membership = &models.GroupMembership{
+		ID:        primitive.NewObjectID(),
+		GroupID:   group.ID,
+		Username:  invitee.Username,
+		Role:      models.GroupMember,
+		Status:    models.InvitedMembership,
+		InvitedBy: inviter.Username,
+		CreatedAt: time.Now(),
+	}
+	if _, err := membershipsCollection.InsertOne(c.Context(), membership); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return groupError(c, errAlreadyGroupMember)
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert group member into database",
+		})
+	}
+	notifyGroup(groupNotification(group, inviter, models.GroupInvitedNotification), []string{invitee.Username})
+
+	return c.JSON(membership)
+}
+
+// ListGroupMembers retrieves a page of the members of a group, owner and moderators first.
+// The status query parameter lists pending invitations or requests instead, which only moderators can see.
+func ListGroupMembers(c *fiber.Ctx) error {
+	// Get a handle to the group members collection
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	// Retrieve the group, making sure the requesting user may see its members
+	group, err := findGroup(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+	status := models.MembershipStatus(c.Query("status", string(models.ActiveMembership)))
+	switch status {
+	case models.ActiveMembership:
+		visible, err := canSeeGroupContent(c.Context(), group, currentUsername(c))
+		if err != nil {
+			return groupError(c, err)
+		}
+		if !visible {
+			return groupError(c, errNotGroupMember)
+		}
+	case models.InvitedMembership, models.RequestedMembership:
+		if _, _, err := findGroupRole(c, models.GroupModerator); err != nil {
+			return groupError(c, err)
+		}
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Status must be active, invited or requested",
+		})
+	}
+
+	// Find the requested page of members, ordering the roles owner, moderator, member
+	page, limit := pageParams(c)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "role", Value: -1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := membershipsCollection.Find(c.Context(), bson.M{"group_id": group.ID, "status": status}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve group members from database",
+		})
+	}
+	memberships := []models.GroupMembership{}
+	if err := cursor.All(c.Context(), &memberships); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve group members from database",
+		})
+	}
+	return c.JSON(memberships)
+}
+
+// ApproveGroupMember approves a user's request to join a group, which its moderators may do
+func ApproveGroupMember(c *fiber.Ctx) error {
+	// Retrieve the group and the request
+	group, moderator, err := findGroupRole(c, models.GroupModerator)
+	if err != nil {
+		return groupError(c, err)
+	}
+	membership, err := findMembership(c.Context(), group.ID, c.Params("member"))
+	if err != nil {
+		return groupError(c, err)
+	}
+	if membership.Status == models.ActiveMembership {
+		return groupError(c, errAlreadyGroupMember)
+	}
+	if membership.Status != models.RequestedMembership {
+		return groupError(c, errMembershipNotFound)
+	}
+
+	// Make the user a member
+	if err := activateMembership(c.Context(), group, membership, moderator); err != nil {
+		return groupError(c, err)
+	}
+	return c.JSON(membership)
+}
+
+// RemoveGroupMember removes a member from a group, declines their request to join, or withdraws their invitation.
+// Moderators can remove members, while only the owner can remove moderators; the owner cannot be removed.
+func RemoveGroupMember(c *fiber.Ctx) error {
+	// Get a handle to the group members collection
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	// Retrieve the group and the membership
+	group, moderator, err := findGroupRole(c, models.GroupModerator)
+	if err != nil {
+		return groupError(c, err)
+	}
+	membership, err := findMembership(c.Context(), group.ID, c.Params("member"))
+	if err != nil {
+		return groupError(c, err)
+	}
+	if membership.Role == models.GroupOwner {
+		return groupError(c, errOwnerLeaving)
+	}
+	if membership.Role == models.GroupModerator && moderator.Username != group.Owner {
+		return groupError(c, errNotGroupOwner)
+	}
+
+	// Delete the membership
+	if _, err := membershipsCollection.DeleteOne(c.Context(), bson.M{"_id": membership.ID}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete group member from database",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Member removed",
+	})
+}
+
+// SetGroupMemberRole changes the role of a member of a group, which only its owner may do.
+// Giving a member the owner role hands the group over to them, leaving the previous owner a moderator.
+func SetGroupMemberRole(c *fiber.Ctx) error {
+	// Get a handle to the groups and group members collections
+	groupsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("groups")
+	membershipsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("group_members")
+
+	// Retrieve the group and the membership
+	group, owner, err := findGroupRole(c, models.GroupOwner)
+	if err != nil {
+		return groupError(c, err)
+	}
+	membership, err := findMembership(c.Context(), group.ID, c.Params("member"))
+	if err != nil {
+		return groupError(c, err)
+	}
+	if membership.Status != models.ActiveMembership {
+		return groupError(c, errMembershipNotFound)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		Role models.GroupRole `json:"role"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+	switch body.Role {
+	case models.GroupModerator, models.GroupMember:
+		if membership.Username == owner.Username {
+			return groupError(c, errOwnerLeaving)
+		}
+	case models.GroupOwner:
+		if membership.Username == owner.Username {
+			return c.JSON(membership)
+		}
+
+		// Hand the group over, leaving the previous owner a moderator
+		update := bson.M{"$set": bson.M{"role": models.GroupModerator}}
+		if _, err := membershipsCollection.UpdateOne(c.Context(), bson.M{"group_id": group.ID, "username": owner.Username}, update); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not update group member in database",
+			})
+		}
+		update = bson.M{"$set": bson.M{"owner": membership.Username, "updated_at": time.Now()}}
+		if _, err := groupsCollection.UpdateOne(c.Context(), bson.M{"_id": group.ID}, update); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not update group in database",
+			})
+		}
+	default:
+		return groupError(c, errGroupRole)
+	}
+
+	// Update the member's role
+	if _, err := membershipsCollection.UpdateOne(c.Context(), bson.M{"_id": membership.ID}, bson.M{"$set": bson.M{"role": body.Role}}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update group member in database",
+		})
+	}
+
+	membership.Role = body.Role
+	return c.JSON(membership)
+}
+
+// CreateGroupPost publishes a post by the requesting user to a group they are a member of, notifying the other members.
+// The posts of a private group can only be seen by its members.
+func CreateGroupPost(c *fiber.Ctx) error {
+	// Retrieve the group and the author
+	group, author, err := findGroupRole(c, models.GroupMember)
+	if err != nil {
+		return groupError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var post models.Post
+	if err := c.BodyParser(&post); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+
+	// Check the poll, if the post has one
+	if post.Poll != nil {
+		if err := preparePoll(post.Poll, time.Now()); err != nil {
+			return pollError(c, err)
+		}
+	}
+
+	// Publish the post to the group, whose privacy decides who can see it
+	post.ID = primitive.NewObjectID()
+	post.GroupID = group.ID
+	post.Visibility = groupPostVisibility(group.Privacy)
+	if err := publishPost(c.Context(), author, &post); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert post into database",
+		})
+	}
+
+	return c.JSON(post)
+}
+
+// GetGroupFeed retrieves a page of the posts in a group, newest first.
+// The posts of a private group are only available to its members.
+func GetGroupFeed(c *fiber.Ctx) error {
+	// Get a handle to the posts collection
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Retrieve the group, making sure the requesting user may see its posts
+	group, err := findGroup(c)
+	if err != nil {
+		return groupError(c, err)
+	}
+	visible, err := canSeeGroupContent(c.Context(), group, currentUsername(c))
+	if err != nil {
+		return groupError(c, err)
+	}
+	if !visible {
+		return groupError(c, errNotGroupMember)
+	}
+
+	// Find the requested page of posts, leaving out those of authors who blocked the requesting user
+	filter, err := visiblePosts(c.Context(), currentUsername(c), notDeleted(bson.M{"group_id": group.ID}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	page, limit := pageParams(c)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := postsCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+	posts := []models.Post{}
+	if err := cursor.All(c.Context(), &posts); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+	return c.JSON(posts)
+}
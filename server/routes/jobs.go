@@ -0,0 +1,137 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// createJob stores a new pending job
+func createJob(ctx context.Context, jobType models.JobType, username string, totalSteps int) (*models.Job, error) {
+	jobsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("jobs")
+
+	now := time.Now()
+	job := models.Job{
+		Type:       jobType,
+		Username:   username,
+		Status:     models.JobPending,
+		TotalSteps: totalSteps,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	res, err := jobsCollection.InsertOne(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	job.ID = res.InsertedID.(primitive.ObjectID)
+	return &job, nil
+}
+
+// updateJobProgress records that a job is running the given step, after completing the steps before it
+func updateJobProgress(ctx context.Context, jobID primitive.ObjectID, step string, completed int) {
+	jobsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("jobs")
+
+	update := bson.M{"$set": bson.M{
+		"status":          models.JobRunning,
+		"step":            step,
+		"steps_completed": completed,
+		"updated_at":      time.Now(),
+	}}
+	if _, err := jobsCollection.UpdateOne(ctx, bson.M{"_id": jobID}, update); err != nil {
+		log.Printf("Could not update job %s: %v", jobID.Hex(), err)
+	}
+}
+
+// finishJob records that a job completed, or failed with the given error, along with any extra fields
+func finishJob(ctx context.Context, job *models.Job, jobErr error, extra bson.M) {
+	jobsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("jobs")
+
+	now := time.Now()
+	fields := bson.M{
+		"status":          models.JobCompleted,
+		"step":            "",
+		"steps_completed": job.TotalSteps,
+		"updated_at":      now,
+		"completed_at":    now,
+	}
+	if jobErr != nil {
+		delete(fields, "steps_completed")
+		fields["status"] = models.JobFailed
+		fields["error"] = jobErr.Error()
+	}
+	for key, value := range extra {
+		fields[key] = value
+	}
+
+	if _, err := jobsCollection.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": fields}); err != nil {
+		log.Printf("Could not update job %s: %v", job.ID.Hex(), err)
+	}
+}
+
+// Errors returned while looking up a job
+var (
+	errInvalidJobID = errors.New("invalid job ID")
+	errNotJobOwner  = errors.New("not the job owner")
+)
+
+// findRequestedJob retrieves the job named by the job_id route parameter, which only the user it runs for may see
+func findRequestedJob(c *fiber.Ctx) (*models.Job, error) {
+	jobsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("jobs")
+
+	jobID, err := primitive.ObjectIDFromHex(c.Params("job_id"))
+	if err != nil {
+		return nil, errInvalidJobID
+	}
+
+	var job models.Job
+	if err := jobsCollection.FindOne(c.Context(), bson.M{"_id": jobID}).Decode(&job); err != nil {
+		return nil, err
+	}
+	if currentUsername(c) != job.Username {
+		return nil, errNotJobOwner
+	}
+	return &job, nil
+}
+
+// jobLookupError writes the response for an error returned by findRequestedJob
+func jobLookupError(c *fiber.Ctx, err error) error {
+	if err == errInvalidJobID {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid job ID",
+		})
+	}
+	if err == errNotJobOwner {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Jobs are only available to the user they run for",
+		})
+	}
+	if err == mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Job not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve job",
+	})
+}
+
+// GetJob retrieves the status of a background job by its ID
+func GetJob(c *fiber.Ctx) error {
+	// Retrieve the job
+	job, err := findRequestedJob(c)
+	if err != nil {
+		return jobLookupError(c, err)
+	}
+
+	return c.JSON(job)
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// acquireLock takes a named lock shared by all web servers through Redis for the given duration.
+// It returns false if another server already holds the lock.
+func acquireLock(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	holder, err := os.Hostname()
+	if err != nil {
+		holder = "unknown"
+	}
+	return rdb.SetNX(ctx, "lock:"+name, holder, ttl).Result()
+}
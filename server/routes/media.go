@@ -0,0 +1,503 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+	"github.com/alexander-winters/SENG468-A2/storage"
+)
+
+// maxAttachments is the number of files that can be attached to a post
+const maxAttachments = 4
+
+// thumbnailSize is the largest width and height of an image thumbnail
+const thumbnailSize = 320
+
+// maxThumbnailPixels is the largest image, in pixels, a thumbnail is generated for
+const maxThumbnailPixels = 40_000_000
+
+// attachmentTypes are the media types files attached to posts may have, as detected from their contents
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"video/mp4":       true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// mediaMaxSize is the largest file, in bytes, that can be attached to a post, configured through MEDIA_MAX_SIZE
+var mediaMaxSize = sizeFromEnv("MEDIA_MAX_SIZE", 10<<20)
+
+// UploadBodyLimit is the largest request body the server accepts, enough for a full set of attachments
+var UploadBodyLimit = int(mediaMaxSize)*maxAttachments + 1<<20
+
+// mediaStore holds the files attached to posts, on local disk below MEDIA_DIR or in an S3-compatible bucket
+var mediaStore = newMediaStore()
+
+// errInvalidAttachmentID is returned when the attachment_id route parameter is not a valid ObjectID
+var errInvalidAttachmentID = errors.New("invalid attachment ID")
+
+// errNotPostAuthor is returned when someone other than a post's author changes its attachments
+var errNotPostAuthor = errors.New("not the post author")
+
+// Errors returned by storeAttachment for a file that cannot be attached
+var (
+	errAttachmentTooLarge = errors.New("attachment too large")
+	errAttachmentType     = errors.New("attachment type not allowed")
+)
+
+// sizeFromEnv reads a size in bytes from an environment variable, falling back to a default if it is unset or invalid
+func sizeFromEnv(name string, fallback int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// newMediaStore creates the media store selected by MEDIA_STORE, either "local" (the default) or "s3".
+// The S3 store is configured through S3_ENDPOINT, S3_BUCKET, S3_REGION, S3_ACCESS_KEY and S3_SECRET_KEY.
+func newMediaStore() storage.Store {
+	if os.Getenv("MEDIA_STORE") == "s3" {
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return storage.NewS3Store(os.Getenv("S3_ENDPOINT"), os.Getenv("S3_BUCKET"), region, os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"))
+	}
+
+	dir := os.Getenv("MEDIA_DIR")
+	if dir == "" {
+		dir = "media"
+	}
+	return storage.NewLocalStore(dir)
+}
+
+// mediaStoreAttempts is how many times PrepareMediaStore tries to reach the object store, which may still be starting up
+const mediaStoreAttempts = 8
+
+// PrepareMediaStore creates the media bucket if files are stored in an S3-compatible object store.
+// It retries with exponential backoff, as the object store may not be ready when the server starts.
+func PrepareMediaStore(ctx context.Context) error {
+	store, ok := mediaStore.(*storage.S3Store)
+	if !ok {
+		return nil
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := store.EnsureBucket(ctx)
+		if err == nil || attempt == mediaStoreAttempts {
+			return err
+		}
+		log.Printf("Media store not ready, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attachmentKey is the media store key of an attached file
+func attachmentKey(postID, attachmentID primitive.ObjectID) string {
+	return fmt.Sprintf("posts/%s/%s", postID.Hex(), attachmentID.Hex())
+}
+
+// thumbnailKey is the media store key of the thumbnail of an attached image
+func thumbnailKey(postID, attachmentID primitive.ObjectID) string {
+	return attachmentKey(postID, attachmentID) + "-thumbnail.png"
+}
+
+// deleteAttachmentFiles removes the files, and their thumbnails, of attachments from the media store
+func deleteAttachmentFiles(ctx context.Context, postID primitive.ObjectID, attachments []models.Attachment) error {
+	for _, attachment := range attachments {
+		if err := mediaStore.Delete(ctx, attachmentKey(postID, attachment.ID)); err != nil {
+			return err
+		}
+		if attachment.HasThumbnail {
+			if err := mediaStore.Delete(ctx, thumbnailKey(postID, attachment.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// attachmentFilename cleans an uploaded file name so it is safe to send back in a Content-Disposition header
+func attachmentFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+// scaleToFit scales an image down to fit within size by size pixels, averaging the pixels each scaled pixel covers
+func scaleToFit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	thumbWidth, thumbHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			thumbWidth, thumbHeight = size, height*size/width
+		} else {
+			thumbWidth, thumbHeight = width*size/height, size
+		}
+	}
+	if thumbWidth < 1 {
+		thumbWidth = 1
+	}
+	if thumbHeight < 1 {
+		thumbHeight = 1
+	}
+
+	thumb := image.NewRGBA64(image.Rect(0, 0, thumbWidth, thumbHeight))
+	for y := 0; y < thumbHeight; y++ {
+		y0, y1 := bounds.Min.Y+y*height/thumbHeight, bounds.Min.Y+(y+1)*height/thumbHeight
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < thumbWidth; x++ {
+			x0, x1 := bounds.Min.X+x*width/thumbWidth, bounds.Min.X+(x+1)*width/thumbWidth
+			if x1 == x0 {
+				x1++
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			thumb.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return thumb
+}
+
+// storeAttachment checks the size and type of an uploaded file and saves it to the media store,
+// together with a thumbnail if it is an image
+func storeAttachment(ctx context.Context, postID primitive.ObjectID, header *multipart.FileHeader) (*models.Attachment, error) {
+	if header.Size > mediaMaxSize {
+		return nil, errAttachmentTooLarge
+	}
+
+	// Read the file, checking its size again in case the header was wrong
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, mediaMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > mediaMaxSize {
+		return nil, errAttachmentTooLarge
+	}
+
+	// The type is detected from the contents, as the one given by the client cannot be trusted
+	contentType := http.DetectContentType(data)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !attachmentTypes[mediaType] {
+		return nil, errAttachmentType
+	}
+
+	attachment := &models.Attachment{
+		ID:          primitive.NewObjectID(),
+		Filename:    attachmentFilename(header.Filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		CreatedAt:   time.Now(),
+	}
+
+	// Generate a thumbnail for images in a format that can be decoded, unless they are too large
+	var thumb bytes.Buffer
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		attachment.Width, attachment.Height = config.Width, config.Height
+		if config.Width*config.Height <= maxThumbnailPixels {
+			if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+				attachment.HasThumbnail = png.Encode(&thumb, scaleToFit(img, thumbnailSize)) == nil
+			}
+		}
+	}
+
+	// Save the file and its thumbnail
+	if err := mediaStore.Put(ctx, attachmentKey(postID, attachment.ID), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	if attachment.HasThumbnail {
+		if err := mediaStore.Put(ctx, thumbnailKey(postID, attachment.ID), &thumb); err != nil {
+			if deleteErr := mediaStore.Delete(ctx, attachmentKey(postID, attachment.ID)); deleteErr != nil {
+				log.Printf("Could not remove attachment %s: %v", attachment.ID.Hex(), deleteErr)
+			}
+			return nil, err
+		}
+	}
+	return attachment, nil
+}
+
+// findRequestedAttachment retrieves the post and attachment named by the route parameters, if the requesting user may see the post
+func findRequestedAttachment(c *fiber.Ctx) (*models.Post, *models.Attachment, error) {
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return nil, nil, mongo.ErrNoDocuments
+	}
+	attachmentID, err := primitive.ObjectIDFromHex(c.Params("attachment_id"))
+	if err != nil {
+		return nil, nil, errInvalidAttachmentID
+	}
+
+	post, err := findVisiblePost(c, c.Params("username"), postNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range post.Attachments {
+		if post.Attachments[i].ID == attachmentID {
+			return post, &post.Attachments[i], nil
+		}
+	}
+	return nil, nil, mongo.ErrNoDocuments
+}
+
+// attachmentLookupError writes the response for an error returned while storing or retrieving an attachment
+func attachmentLookupError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errAttachmentTooLarge:
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": fmt.Sprintf("Attachments can be at most %d bytes", mediaMaxSize),
+		})
+	case errAttachmentType:
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "Attachment type not allowed",
+		})
+	case errNotPostAuthor:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can change the attachments of a post",
+		})
+	case errInvalidAttachmentID:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid attachment ID",
+		})
+	case mongo.ErrNoDocuments:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Attachment not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve attachment",
+	})
+}
+
+// UploadAttachments attaches the files in the "files" field of a multipart form to a post of the requesting user
+func UploadAttachments(c *fiber.Ctx) error {
+	// Get a handle to the posts collection
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Get the username and post number from the request parameters
+	username := c.Params("username")
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+	if currentUsername(c) != username {
+		return attachmentLookupError(c, errNotPostAuthor)
+	}
+
+	// Retrieve the post
+	post, err := GetPostByUsername(username, postNumber)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+
+	// Read the uploaded files
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse multipart form",
+		})
+	}
+	files := form.File["files"]
+	if len(files) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No files uploaded",
+		})
+	}
+	if len(post.Attachments)+len(files) > maxAttachments {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("A post can have at most %d attachments", maxAttachments),
+		})
+	}
+
+	// Save the files to the media store
+	attachments := make([]models.Attachment, 0, len(files))
+	for _, header := range files {
+		attachment, err := storeAttachment(c.Context(), post.ID, header)
+		if err != nil {
+			if cleanupErr := deleteAttachmentFiles(c.Context(), post.ID, attachments); cleanupErr != nil {
+				log.Printf("Could not remove attachments of post %s: %v", post.ID.Hex(), cleanupErr)
+			}
+			return attachmentLookupError(c, err)
+		}
+		attachments = append(attachments, *attachment)
+	}
+
+	// Add the attachments to the post, as long as it still has room for them
+	filter := notDeleted(bson.M{
+		"_id": post.ID,
+		fmt.Sprintf("attachments.%d", maxAttachments-len(attachments)): bson.M{"$exists": false},
+	})
+	update := bson.M{"$push": bson.M{"attachments": bson.M{"$each": attachments}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updatedPost models.Post
+	if err := postsCollection.FindOneAndUpdate(c.Context(), filter, update, opts).Decode(&updatedPost); err != nil {
+		if cleanupErr := deleteAttachmentFiles(c.Context(), post.ID, attachments); cleanupErr != nil {
+			log.Printf("Could not remove attachments of post %s: %v", post.ID.Hex(), cleanupErr)
+		}
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": fmt.Sprintf("A post can have at most %d attachments", maxAttachments),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update post in database",
+		})
+	}
+
+	// Update the post in Redis cache
+	if err := cachePost(c.Context(), &updatedPost); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store post in Redis",
+		})
+	}
+
+	return c.JSON(updatedPost)
+}
+
+// DownloadAttachment sends a file attached to a post the requesting user may see.
+// The thumbnail query parameter set to "true" sends the thumbnail of an image instead.
+func DownloadAttachment(c *fiber.Ctx) error {
+	// Retrieve the post and attachment
+	post, attachment, err := findRequestedAttachment(c)
+	if err != nil {
+		return attachmentLookupError(c, err)
+	}
+
+	key, contentType := attachmentKey(post.ID, attachment.ID), attachment.ContentType
+	if c.Query("thumbnail") == "true" {
+		if !attachment.HasThumbnail {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Attachment has no thumbnail",
+			})
+		}
+		key, contentType = thumbnailKey(post.ID, attachment.ID), "image/png"
+	}
+
+	// Stream the file from the media store
+	file, err := mediaStore.Get(c.Context(), key)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return attachmentLookupError(c, mongo.ErrNoDocuments)
+		}
+		return attachmentLookupError(c, err)
+	}
+
+	// Images are shown in the browser, other files are downloaded
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	return c.SendStream(file)
+}
+
+// DeleteAttachment removes a file attached to a post of the requesting user
+func DeleteAttachment(c *fiber.Ctx) error {
+	// Get a handle to the posts collection
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Retrieve the post and attachment
+	if currentUsername(c) != c.Params("username") {
+		return attachmentLookupError(c, errNotPostAuthor)
+	}
+	post, attachment, err := findRequestedAttachment(c)
+	if err != nil {
+		return attachmentLookupError(c, err)
+	}
+
+	// Remove the attachment from the post
+	update := bson.M{"$pull": bson.M{"attachments": bson.M{"_id": attachment.ID}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updatedPost models.Post
+	if err := postsCollection.FindOneAndUpdate(c.Context(), bson.M{"_id": post.ID}, update, opts).Decode(&updatedPost); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update post in database",
+		})
+	}
+
+	// Update the post in Redis cache
+	if err := cachePost(c.Context(), &updatedPost); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store post in Redis",
+		})
+	}
+
+	// Remove the file from the media store
+	if err := deleteAttachmentFiles(c.Context(), post.ID, []models.Attachment{*attachment}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove attachment from media store",
+		})
+	}
+
+	return c.JSON(updatedPost)
+}
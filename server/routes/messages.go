@@ -0,0 +1,622 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// Limits on conversations and the messages sent in them
+const (
+	maxConversationMembers    = 8
+	maxConversationNameLength = 50
+	maxMessageLength          = 2000
+)
+
+// Errors returned while finding or changing conversations and their messages
+var (
+	errNotConversationOwner = errors.New("not the conversation owner")
+	errUnknownSender        = errors.New("unknown sending user")
+	errUnknownParticipant   = errors.New("unknown participant")
+	errNotFriends           = errors.New("participants are not friends")
+	errConversationSize     = errors.New("invalid number of participants")
+	errConversationName     = errors.New("invalid conversation name")
+	errConversationNotFound = errors.New("conversation not found")
+	errMessageContent       = errors.New("invalid message content")
+	errMessageNotFound      = errors.New("message not found")
+	errNotMessageAuthor     = errors.New("not the message author")
+)
+
+// messageError writes the response for an error returned while finding or changing conversations and their messages
+func messageError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errNotConversationOwner:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Conversations are only available to their participants",
+		})
+	case errUnknownSender:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown user",
+		})
+	case errUnknownParticipant:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Participant not found",
+		})
+	case errNotFriends:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Messages can only be sent to confirmed friends",
+		})
+	case errConversationSize:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Conversations must have between 2 and %d participants", maxConversationMembers),
+		})
+	case errConversationName:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Conversation names can be at most %d characters and only given to group conversations", maxConversationNameLength),
+		})
+	case errConversationNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Conversation not found",
+		})
+	case errMessageContent:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Messages must be 1 to %d characters", maxMessageLength),
+		})
+	case errMessageNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Message not found",
+		})
+	case errNotMessageAuthor:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the sender can change a message",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve conversation",
+	})
+}
+
+// confirmedFriends reports whether two users list each other as friends and neither has blocked the other
+func confirmedFriends(a, b *models.User) bool {
+	listed := func(list []string, username string) bool {
+		for _, name := range list {
+			if name == username {
+				return true
+			}
+		}
+		return false
+	}
+	return listed(a.ListOfFriends, b.Username) && listed(b.ListOfFriends, a.Username) &&
+		!listed(a.BlockedUsers, b.Username) && !listed(b.BlockedUsers, a.Username)
+}
+
+// messageContent cleans the content of a message, which must not be empty
+func messageContent(content string) (string, error) {
+	content = strings.TrimSpace(content)
+	if content == "" || utf8.RuneCountInString(content) > maxMessageLength {
+		return "", errMessageContent
+	}
+	return content, nil
+}
+
+// findRequestingUser retrieves the requesting user, who must exist to take part in conversations
+func findRequestingUser(c *fiber.Ctx) (*models.User, error) {
+	user, err := GetUserByUsername(currentUsername(c))
+	if err == mongo.ErrNoDocuments {
+		return nil, errUnknownSender
+	}
+	return user, err
+}
+
+// findConversation retrieves the conversation named by the conversation_id route parameter, which the requesting user must take part in.
+// Conversations the user is not part of are reported as not found.
+func findConversation(c *fiber.Ctx) (*models.Conversation, *models.User, error) {
+	conversationsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("conversations")
+
+	user, err := findRequestingUser(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := primitive.ObjectIDFromHex(c.Params("conversation_id"))
+	if err != nil {
+		return nil, nil, errConversationNotFound
+	}
+
+	var conversation models.Conversation
+	filter := bson.M{"_id": id, "members.username": user.Username}
+	if err := conversationsCollection.FindOne(c.Context(), filter).Decode(&conversation); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errConversationNotFound
+		}
+		return nil, nil, err
+	}
+	return &conversation, user, nil
+}
+
+// findConversationMessage retrieves the message named by the message_id route parameter from a conversation
+func findConversationMessage(c *fiber.Ctx, conversation *models.Conversation) (*models.Message, error) {
+	messagesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("messages")
+
+	id, err := primitive.ObjectIDFromHex(c.Params("message_id"))
+	if err != nil {
+		return nil, errMessageNotFound
+	}
+	var message models.Message
+	if err := messagesCollection.FindOne(c.Context(), bson.M{"_id": id, "conversation_id": conversation.ID}).Decode(&message); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errMessageNotFound
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+// readBy lists the participants other than its sender who have read a message
+func readBy(conversation *models.Conversation, message *models.Message) []string {
+	readers := []string{}
+	for _, member := range conversation.Members {
+		if member.Username != message.Username && !member.LastReadAt.Before(message.CreatedAt) {
+			readers = append(readers, member.Username)
+		}
+	}
+	return readers
+}
+
+// unreadCount counts the messages in a conversation sent by others after username last read it
+func unreadCount(ctx context.Context, conversation *models.Conversation, username string) (int64, error) {
+	messagesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("messages")
+
+	filter := bson.M{"conversation_id": conversation.ID, "username": bson.M{"$ne": username}}
+	for _, member := range conversation.Members {
+		if member.Username == username {
+			filter["created_at"] = bson.M{"$gt": member.LastReadAt}
+		}
+	}
+	return messagesCollection.CountDocuments(ctx, filter)
+}
+
+// directKey identifies the conversation between two users, whichever of them started it
+func directKey(a, b string) string {
+	names := []string{a, b}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// CreateConversation starts a conversation between the requesting user and the participants in the request body,
+// who must all be confirmed friends of theirs. A conversation with a single other participant is a direct conversation,
+// and starting one again returns the existing conversation; groups of more can be given a name.
+func CreateConversation(c *fiber.Ctx) error {
+	// Get a handle to the conversations collection
+	conversationsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("conversations")
+
+	// Retrieve the user starting the conversation
+	if currentUsername(c) != c.Params("username") {
+		return messageError(c, errNotConversationOwner)
+	}
+	creator, err := findRequestingUser(c)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		Participants []string `json:"participants"`
+		Name         string   `json:"name"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+
+	// Check the participants are distinct friends of the creator
+	now := time.Now().Truncate(time.Millisecond)
+	members := []models.ConversationMember{{Username: creator.Username, LastReadAt: now}}
+	seen := map[string]bool{creator.Username: true}
+	for _, username := range body.Participants {
+		if seen[username] {
+			continue
+		}
+		seen[username] = true
+
+		participant, err := GetUserByUsername(username)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return messageError(c, errUnknownParticipant)
+			}
+			return messageError(c, err)
+		}
+		if !confirmedFriends(creator, participant) {
+			return messageError(c, errNotFriends)
+		}
+		members = append(members, models.ConversationMember{Username: participant.Username})
+	}
+	if len(members) < 2 || len(members) > maxConversationMembers {
+		return messageError(c, errConversationSize)
+	}
+	name := strings.TrimSpace(body.Name)
+	group := len(members) > 2
+	if (name != "" && !group) || utf8.RuneCountInString(name) > maxConversationNameLength {
+		return messageError(c, errConversationName)
+	}
+
+	conversation := models.Conversation{
+		ID:            primitive.NewObjectID(),
+		Name:          name,
+		Group:         group,
+		Members:       members,
+		CreatedBy:     creator.Username,
+		CreatedAt:     now,
+		LastMessageAt: now,
+	}
+
+	// Insert a group conversation
+	if group {
+		if _, err := conversationsCollection.InsertOne(c.Context(), conversation); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not insert conversation into database",
+			})
+		}
+		return c.JSON(conversation)
+	}
+
+	// Insert a direct conversation, unless the two users already have one
+	conversation.DirectKey = directKey(members[0].Username, members[1].Username)
+	filter := bson.M{"direct_key": conversation.DirectKey}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var stored models.Conversation
+	if err := conversationsCollection.FindOneAndUpdate(c.Context(), filter, bson.M{"$setOnInsert": conversation}, opts).Decode(&stored); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert conversation into database",
+		})
+	}
+	stored.UnreadCount, err = unreadCount(c.Context(), &stored, creator.Username)
+	if err != nil {
+		return messageError(c, err)
+	}
+	return c.JSON(stored)
+}
+
+// ListConversations retrieves a page of the requesting user's conversations, most recently active first,
+// with how many unread messages each holds
+func ListConversations(c *fiber.Ctx) error {
+	// Get a handle to the conversations collection
+	conversationsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("conversations")
+
+	// Retrieve the user
+	if currentUsername(c) != c.Params("username") {
+		return messageError(c, errNotConversationOwner)
+	}
+	user, err := findRequestingUser(c)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	// Find the requested page of conversations
+	page, limit := pageParams(c)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "last_message_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := conversationsCollection.Find(c.Context(), bson.M{"members.username": user.Username}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve conversations from database",
+		})
+	}
+	conversations := []models.Conversation{}
+	if err := cursor.All(c.Context(), &conversations); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve conversations from database",
+		})
+	}
+
+	// Count the unread messages in each conversation
+	for i := range conversations {
+		if conversations[i].UnreadCount, err = unreadCount(c.Context(), &conversations[i], user.Username); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not count unread messages",
+			})
+		}
+	}
+
+	return c.JSON(conversations)
+}
+
+// GetConversation retrieves one of the requesting user's conversations
+func GetConversation(c *fiber.Ctx) error {
+	conversation, user, err := findConversation(c)
+	if err != nil {
+		return messageError(c, err)
+	}
+	if conversation.UnreadCount, err = unreadCount(c.Context(), conversation, user.Username); err != nil {
+		return messageError(c, err)
+	}
+	return c.JSON(conversation)
+}
+
+// SendMessage sends a message to a conversation of the requesting user, notifying the other participants.
+// Direct messages can only be sent while the two users are still confirmed friends.
+func SendMessage(c *fiber.Ctx) error {
+	// Get a handle to the conversations and messages collections
+	conversationsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("conversations")
+	messagesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("messages")
+
+	// Retrieve the conversation
+	conversation, sender, err := findConversation(c)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+	content, err := messageContent(body.Content)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	// Check the two users of a direct conversation are still friends
+	if !conversation.Group {
+		for _, member := range conversation.Members {
+			if member.Username == sender.Username {
+				continue
+			}
+			recipient, err := GetUserByUsername(member.Username)
+			if err != nil && err != mongo.ErrNoDocuments {
+				return messageError(c, err)
+			}
+			if err == mongo.ErrNoDocuments || !confirmedFriends(sender, recipient) {
+				return messageError(c, errNotFriends)
+			}
+		}
+	}
+
+	// Insert the message, which the sender has read
+	message := models.Message{
+		ID:             primitive.NewObjectID(),
+		ConversationID: conversation.ID,
+		Username:       sender.Username,
+		Content:        content,
+		CreatedAt:      time.Now().Truncate(time.Millisecond),
+	}
+	if _, err := messagesCollection.InsertOne(c.Context(), message); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert message into database",
+		})
+	}
+	filter := bson.M{"_id": conversation.ID, "members.username": sender.Username}
+	update := bson.M{"$set": bson.M{"last_message_at": message.CreatedAt, "members.$.last_read_at": message.CreatedAt}}
+	if _, err := conversationsCollection.UpdateOne(c.Context(), filter, update); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update conversation in database",
+		})
+	}
+
+	// Notify the other participants
+	for _, member := range conversation.Members {
+		if member.Username == sender.Username {
+			continue
+		}
+		notification := models.Notification{
+			ID:         primitive.NewObjectID(),
+			UserID:     sender.ID,
+			Username:   sender.Username,
+			Type:       models.MessageNotification,
+			MessageID:  message.ID,
+			Recipient:  member.Username,
+			Content:    message.Content,
+			ReadStatus: false,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		sendNotification(notification, "message")
+	}
+
+	message.ReadBy = []string{}
+	return c.JSON(message)
+}
+
+// ListMessages retrieves a page of the messages in a conversation of the requesting user, newest first,
+// with the participants who have read each one
+func ListMessages(c *fiber.Ctx) error {
+	// Get a handle to the messages collection
+	messagesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("messages")
+
+	// Retrieve the conversation
+	conversation, _, err := findConversation(c)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	// Find the requested page of messages
+	page, limit := pageParams(c)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := messagesCollection.Find(c.Context(), bson.M{"conversation_id": conversation.ID}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve messages from database",
+		})
+	}
+	messages := []models.Message{}
+	if err := cursor.All(c.Context(), &messages); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve messages from database",
+		})
+	}
+
+	for i := range messages {
+		messages[i].ReadBy = readBy(conversation, &messages[i])
+	}
+	return c.JSON(messages)
+}
+
+// EditMessage changes the content of a message the requesting user sent
+func EditMessage(c *fiber.Ctx) error {
+	// Get a handle to the messages collection
+	messagesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("messages")
+
+	// Retrieve the conversation and the message
+	conversation, user, err := findConversation(c)
+	if err != nil {
+		return messageError(c, err)
+	}
+	message, err := findConversationMessage(c, conversation)
+	if err != nil {
+		return messageError(c, err)
+	}
+	if message.Username != user.Username {
+		return messageError(c, errNotMessageAuthor)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+	content, err := messageContent(body.Content)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	// Update the message
+	editedAt := time.Now()
+	update := bson.M{"$set": bson.M{"content": content, "edited_at": editedAt}}
+	if _, err := messagesCollection.UpdateOne(c.Context(), bson.M{"_id": message.ID}, update); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update message in database",
+		})
+	}
+
+	message.Content = content
+	message.EditedAt = &editedAt
+	message.ReadBy = readBy(conversation, message)
+	return c.JSON(message)
+}
+
+// DeleteMessage removes a message the requesting user sent from a conversation
+func DeleteMessage(c *fiber.Ctx) error {
+	// Get a handle to the messages collection
+	messagesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("messages")
+
+	// Retrieve the conversation and the message
+	conversation, user, err := findConversation(c)
+	if err != nil {
+		return messageError(c, err)
+	}
+	message, err := findConversationMessage(c, conversation)
+	if err != nil {
+		return messageError(c, err)
+	}
+	if message.Username != user.Username {
+		return messageError(c, errNotMessageAuthor)
+	}
+
+	// Delete the message and the notifications sent for it
+	if _, err := messagesCollection.DeleteOne(c.Context(), bson.M{"_id": message.ID}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete message from database",
+		})
+	}
+	notificationsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("notifications")
+	if _, err := notificationsCollection.DeleteMany(c.Context(), bson.M{"message_id": message.ID}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete notifications from database",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Message deleted",
+	})
+}
+
+// MarkConversationRead records that the requesting user has read a conversation up to the message_id in the request body,
+// or up to its latest message. Read receipts only move forward.
+func MarkConversationRead(c *fiber.Ctx) error {
+	// Get a handle to the conversations and messages collections
+	conversationsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("conversations")
+	messagesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("messages")
+
+	// Retrieve the conversation
+	conversation, user, err := findConversation(c)
+	if err != nil {
+		return messageError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		MessageID string `json:"message_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Could not parse request body",
+			})
+		}
+	}
+
+	// Find the message read up to
+	var message models.Message
+	filter := bson.M{"conversation_id": conversation.ID}
+	if body.MessageID != "" {
+		id, err := primitive.ObjectIDFromHex(body.MessageID)
+		if err != nil {
+			return messageError(c, errMessageNotFound)
+		}
+		filter["_id"] = id
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+	if err := messagesCollection.FindOne(c.Context(), filter, opts).Decode(&message); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return messageError(c, errMessageNotFound)
+		}
+		return messageError(c, err)
+	}
+
+	// Move the user's read receipt forward
+	filter = bson.M{
+		"_id":     conversation.ID,
+		"members": bson.M{"$elemMatch": bson.M{"username": user.Username, "last_read_at": bson.M{"$lt": message.CreatedAt}}},
+	}
+	update := bson.M{"$set": bson.M{"members.$.last_read_at": message.CreatedAt}}
+	if _, err := conversationsCollection.UpdateOne(c.Context(), filter, update); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update conversation in database",
+		})
+	}
+
+	// Return the conversation as it is now
+	if err := conversationsCollection.FindOne(c.Context(), bson.M{"_id": conversation.ID}).Decode(conversation); err != nil {
+		return messageError(c, err)
+	}
+	if conversation.UnreadCount, err = unreadCount(c.Context(), conversation, user.Username); err != nil {
+		return messageError(c, err)
+	}
+	return c.JSON(conversation)
+}
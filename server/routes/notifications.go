@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"log"
+
+	"github.com/alexander-winters/SENG468-A2/kafka-docker/kafkaService"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// sendNotification publishes a notification to its recipient through Kafka, logging any failure.
+// Notifications caused by a user the recipient has blocked are dropped.
+func sendNotification(notification models.Notification, topic string) {
+	if blocked, err := hasBlocked(notification.Recipient, notification.Username); err == nil && blocked {
+		return
+	}
+
+	// Initialize Kafka producer and consumer
+	kafkaProducer := kafkaService.CreateKafkaProducer(kafkaBrokerURL)
+	kafkaConsumer := kafkaService.CreateKafkaConsumer(kafkaBrokerURL, topic)
+
+	ks := kafkaService.NewKafkaService(kafkaProducer, kafkaConsumer)
+
+	// Send the notification to the recipient
+	if err := ks.SendUserNotification(notification); err != nil {
+		log.Printf("Could not send notification: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+
+	// maxPage keeps the offset of a page, (page-1)*limit, well clear of overflowing
+	maxPage = 10000
+)
+
+// pageParams reads the page and limit query parameters, falling back to the first page of defaultPageLimit items
+func pageParams(c *fiber.Ctx) (page, limit int) {
+	page = c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+	if page > maxPage {
+		page = maxPage
+	}
+
+	limit = c.QueryInt("limit", defaultPageLimit)
+	if limit < 1 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	return page, limit
+}
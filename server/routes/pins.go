@@ -0,0 +1,370 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// maxPinnedPosts is the most posts a user can pin to their profile
+const maxPinnedPosts = 3
+
+// Errors returned while pinning, unpinning or ordering posts
+var (
+	errNotPinOwner    = errors.New("not the pinning user")
+	errTooManyPins    = errors.New("too many pinned posts")
+	errNotPinned      = errors.New("post not pinned")
+	errPinPosition    = errors.New("invalid pin position")
+	errPinOrder       = errors.New("pin order does not match pinned posts")
+	errInvalidPinPost = errors.New("invalid post number")
+)
+
+// pinError writes the response for an error returned while pinning, unpinning or ordering posts
+func pinError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errNotPinOwner:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can pin their posts",
+		})
+	case errTooManyPins:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": fmt.Sprintf("At most %d posts can be pinned", maxPinnedPosts),
+		})
+	case errNotPinned:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Post not pinned",
+		})
+	case errPinPosition:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Position must be between 1 and %d", maxPinnedPosts),
+		})
+	case errPinOrder:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Post numbers must list each pinned post exactly once",
+		})
+	case errInvalidPinPost:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	case mongo.ErrNoDocuments:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Post not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve pinned posts",
+	})
+}
+
+// findPinOwner retrieves the user named by the username route parameter, who must be the requesting user
+func findPinOwner(c *fiber.Ctx) (*models.User, error) {
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return nil, errNotPinOwner
+	}
+	return GetUserByUsername(username)
+}
+
+// findPinnedPost retrieves the live post of the pinning user named by the post_number route parameter
+func findPinnedPost(c *fiber.Ctx, user *models.User) (*models.Post, error) {
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return nil, errInvalidPinPost
+	}
+	return GetPostByUsername(user.Username, postNumber)
+}
+
+// freshPins reads a user's pinned posts from the database, bypassing the cache
+func freshPins(ctx context.Context, username string) ([]primitive.ObjectID, error) {
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"pinned_posts": 1})
+	if err := usersCollection.FindOne(ctx, notDeleted(bson.M{"username": username}), opts).Decode(&user); err != nil {
+		return nil, err
+	}
+	return user.PinnedPosts, nil
+}
+
+// pinnedPosts retrieves the posts a user pinned that viewer may see, in the order they were pinned
+func pinnedPosts(ctx context.Context, pins []primitive.ObjectID, viewer string) ([]models.Post, error) {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	posts := []models.Post{}
+	if len(pins) == 0 {
+		return posts, nil
+	}
+	filter, err := visiblePosts(ctx, viewer, notDeleted(bson.M{"_id": bson.M{"$in": pins}}))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := postsCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var found []models.Post
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	// Put the posts in pin order
+	byID := make(map[primitive.ObjectID]models.Post, len(found))
+	for _, post := range found {
+		byID[post.ID] = post
+	}
+	for _, id := range pins {
+		if post, ok := byID[id]; ok {
+			post.Pinned = true
+			posts = append(posts, post)
+		}
+	}
+	return posts, nil
+}
+
+// unpinPosts removes the given posts from the profiles they are pinned to, returning the usernames of those profiles
+func unpinPosts(ctx context.Context, postIDs []primitive.ObjectID) ([]string, error) {
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	filter := bson.M{"pinned_posts": bson.M{"$in": postIDs}}
+	cursor, err := usersCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"username": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	if _, err := usersCollection.UpdateMany(ctx, filter, bson.M{"$pull": bson.M{"pinned_posts": bson.M{"$in": postIDs}}}); err != nil {
+		return nil, err
+	}
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	return usernames, nil
+}
+
+// pinsChanged responds with the user's pinned posts after they were changed, dropping the cached user so listings see the new pins
+func pinsChanged(c *fiber.Ctx, username string) error {
+	if err := rdb.Del(c.Context(), "user:"+username, username).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update user in Redis cache",
+		})
+	}
+
+	pins, err := freshPins(c.Context(), username)
+	if err != nil {
+		return pinError(c, err)
+	}
+	posts, err := pinnedPosts(c.Context(), pins, username)
+	if err != nil {
+		return pinError(c, err)
+	}
+	return c.JSON(posts)
+}
+
+// PinPost pins one of the requesting user's posts to their profile, so it is listed before their other posts.
+// The position field of the request body places it among the pinned posts, counting from 1; it goes last otherwise.
+// Pinning a post that is already pinned leaves the pins as they are.
+func PinPost(c *fiber.Ctx) error {
+	// Get a handle to the users collection
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	// Retrieve the user and the post
+	user, err := findPinOwner(c)
+	if err != nil {
+		return pinError(c, err)
+	}
+	post, err := findPinnedPost(c, user)
+	if err != nil {
+		return pinError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		Position int `json:"position"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Could not parse request body",
+			})
+		}
+	}
+	if body.Position < 0 || body.Position > maxPinnedPosts {
+		return pinError(c, errPinPosition)
+	}
+
+	// Pin the post, as long as it is not pinned yet and the user has room for another pin
+	push := bson.M{"$each": bson.A{post.ID}}
+	if body.Position > 0 {
+		push["$position"] = body.Position - 1
+	}
+	filter := notDeleted(bson.M{
+		"username": user.Username,
+		"$and": bson.A{
+			bson.M{"pinned_posts": bson.M{"$ne": post.ID}},
+			bson.M{fmt.Sprintf("pinned_posts.%d", maxPinnedPosts-1): bson.M{"$exists": false}},
+		},
+	})
+	res, err := usersCollection.UpdateOne(c.Context(), filter, bson.M{"$push": bson.M{"pinned_posts": push}})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not pin post",
+		})
+	}
+
+	// Tell apart a post that was already pinned from a user with no room left
+	if res.MatchedCount == 0 {
+		pins, err := freshPins(c.Context(), user.Username)
+		if err != nil {
+			return pinError(c, err)
+		}
+		pinned := false
+		for _, id := range pins {
+			if id == post.ID {
+				pinned = true
+			}
+		}
+		if !pinned {
+			return pinError(c, errTooManyPins)
+		}
+	}
+
+	return pinsChanged(c, user.Username)
+}
+
+// UnpinPost removes one of the requesting user's posts from the posts pinned to their profile
+func UnpinPost(c *fiber.Ctx) error {
+	// Get a handle to the users collection
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	// Retrieve the user and the post
+	user, err := findPinOwner(c)
+	if err != nil {
+		return pinError(c, err)
+	}
+	post, err := findPinnedPost(c, user)
+	if err != nil {
+		return pinError(c, err)
+	}
+
+	// Unpin the post
+	filter := notDeleted(bson.M{"username": user.Username, "pinned_posts": post.ID})
+	res, err := usersCollection.UpdateOne(c.Context(), filter, bson.M{"$pull": bson.M{"pinned_posts": post.ID}})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not unpin post",
+		})
+	}
+	if res.MatchedCount == 0 {
+		return pinError(c, errNotPinned)
+	}
+
+	return pinsChanged(c, user.Username)
+}
+
+// ListPinnedPosts retrieves the posts a user pinned to their profile that the requesting user may see, in pin order
+func ListPinnedPosts(c *fiber.Ctx) error {
+	// Retrieve the user
+	user, err := findProfileUser(c)
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	// Find the pinned posts
+	posts, err := pinnedPosts(c.Context(), user.PinnedPosts, currentUsername(c))
+	if err != nil {
+		return pinError(c, err)
+	}
+	return c.JSON(posts)
+}
+
+// ReorderPins changes the order of the posts pinned to the requesting user's profile.
+// The post_numbers field of the request body must list every pinned post once, in the new order.
+func ReorderPins(c *fiber.Ctx) error {
+	// Get a handle to the users and posts collections
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Retrieve the user
+	user, err := findPinOwner(c)
+	if err != nil {
+		return pinError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		PostNumbers []int `json:"post_numbers"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+
+	// Look up the posts, which must all be distinct posts of the user
+	seen := make(map[int]bool, len(body.PostNumbers))
+	for _, number := range body.PostNumbers {
+		if seen[number] {
+			return pinError(c, errPinOrder)
+		}
+		seen[number] = true
+	}
+	filter := bson.M{"username": user.Username, "post_number": bson.M{"$in": body.PostNumbers}}
+	cursor, err := postsCollection.Find(c.Context(), filter, options.Find().SetProjection(bson.M{"post_number": 1}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+	var posts []models.Post
+	if err := cursor.All(c.Context(), &posts); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+	ids := make(map[int]primitive.ObjectID, len(posts))
+	for _, post := range posts {
+		ids[post.PostNumber] = post.ID
+	}
+	order := make(bson.A, 0, len(body.PostNumbers))
+	for _, number := range body.PostNumbers {
+		id, ok := ids[number]
+		if !ok {
+			return pinError(c, errPinOrder)
+		}
+		order = append(order, id)
+	}
+
+	// Reorder the pins, as long as the posts listed are exactly the ones pinned
+	if len(order) == 0 {
+		return pinError(c, errPinOrder)
+	}
+	filter = notDeleted(bson.M{"username": user.Username, "pinned_posts": bson.M{"$size": len(order), "$all": order}})
+	res, err := usersCollection.UpdateOne(c.Context(), filter, bson.M{"$set": bson.M{"pinned_posts": order}})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not reorder pinned posts",
+		})
+	}
+	if res.MatchedCount == 0 {
+		return pinError(c, errPinOrder)
+	}
+
+	return pinsChanged(c, user.Username)
+}
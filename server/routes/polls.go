@@ -0,0 +1,443 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// Limits on the options of a poll
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 10
+	maxPollOptionLength = 100
+)
+
+// pollCloseInterval is how often polls past their close time are closed, configured through POLL_CLOSE_INTERVAL
+var pollCloseInterval = durationFromEnv("POLL_CLOSE_INTERVAL", time.Minute)
+
+// Errors returned for a poll or vote that is not valid
+var (
+	errPollOptions           = errors.New("invalid poll options")
+	errPollClosesAt          = errors.New("poll close time is not after it opens")
+	errPollResultsVisibility = errors.New("invalid poll results visibility")
+	errNoPoll                = errors.New("post has no poll")
+	errPollClosed            = errors.New("poll is closed")
+	errInvalidVote           = errors.New("invalid vote")
+	errUnknownVoter          = errors.New("unknown voter")
+)
+
+// preparePoll checks a new poll, which opens at the given time, and resets the state the server maintains.
+// Options are numbered in the order they are given, and results are visible after voting unless stated otherwise.
+func preparePoll(poll *models.Poll, opensAt time.Time) error {
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		return errPollOptions
+	}
+	for i := range poll.Options {
+		text := strings.TrimSpace(poll.Options[i].Text)
+		if text == "" || utf8.RuneCountInString(text) > maxPollOptionLength {
+			return errPollOptions
+		}
+		poll.Options[i] = models.PollOption{ID: i, Text: text}
+	}
+
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(opensAt) {
+		return errPollClosesAt
+	}
+
+	switch poll.ResultsVisibility {
+	case "":
+		poll.ResultsVisibility = models.ResultsAfterVote
+	case models.ResultsAfterVote, models.ResultsAfterClose:
+	default:
+		return errPollResultsVisibility
+	}
+
+	poll.Closed = false
+	poll.TotalVoters = 0
+	return nil
+}
+
+// pollClosed reports whether a poll no longer accepts votes
+func pollClosed(poll *models.Poll) bool {
+	return poll.Closed || (poll.ClosesAt != nil && !time.Now().Before(*poll.ClosesAt))
+}
+
+// pollError writes the response for an error returned while creating, voting in or retrieving a poll
+func pollError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errPollOptions:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("A poll needs %d to %d options of at most %d characters", minPollOptions, maxPollOptions, maxPollOptionLength),
+		})
+	case errPollClosesAt:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Poll close time must be after the post is published",
+		})
+	case errPollResultsVisibility:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid poll results visibility",
+		})
+	case errInvalidVote:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid vote",
+		})
+	case errUnknownVoter:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown user",
+		})
+	case errPollClosed:
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Poll is closed",
+		})
+	case errNoPoll:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Post has no poll",
+		})
+	case mongo.ErrNoDocuments:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Post not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve poll",
+	})
+}
+
+// findRequestedPoll retrieves the post named by the route parameters, which must have a poll the requesting user may see
+func findRequestedPoll(c *fiber.Ctx) (*models.Post, error) {
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	post, err := findVisiblePost(c, c.Params("username"), postNumber)
+	if err != nil {
+		return nil, err
+	}
+	if post.Poll == nil {
+		return nil, errNoPoll
+	}
+	return post, nil
+}
+
+// findPollVote retrieves a user's vote in the poll on a post, or nil if they have not voted
+func findPollVote(ctx context.Context, post *models.Post, username string) (*models.PollVote, error) {
+	votesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("poll_votes")
+
+	var vote models.PollVote
+	if err := votesCollection.FindOne(ctx, bson.M{"post_id": post.ID, "username": username}).Decode(&vote); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &vote, nil
+}
+
+// pollResults builds the poll on a post as seen by viewer.
+// The author always sees the counts, and everyone else after voting or once the poll has closed, as the poll allows.
+func pollResults(ctx context.Context, post *models.Post, viewer string) (*models.PollResults, error) {
+	// Read the vote counts from the database, as cached posts do not carry them
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	var current models.Post
+	if err := postsCollection.FindOne(ctx, bson.M{"_id": post.ID}).Decode(&current); err != nil {
+		return nil, err
+	}
+	if current.Poll == nil {
+		return nil, errNoPoll
+	}
+	poll := current.Poll
+
+	results := &models.PollResults{
+		MultipleChoice: poll.MultipleChoice,
+		ClosesAt:       poll.ClosesAt,
+		Closed:         pollClosed(poll),
+		Vote:           []int{},
+	}
+
+	if viewer != "" {
+		vote, err := findPollVote(ctx, post, viewer)
+		if err != nil {
+			return nil, err
+		}
+		if vote != nil {
+			results.Vote = vote.Options
+		}
+	}
+
+	switch {
+	case viewer != "" && viewer == post.Username, results.Closed:
+		results.ResultsVisible = true
+	case poll.ResultsVisibility == models.ResultsAfterVote:
+		results.ResultsVisible = len(results.Vote) > 0
+	}
+
+	for _, option := range poll.Options {
+		result := models.PollOptionResult{ID: option.ID, Text: option.Text}
+		if results.ResultsVisible {
+			votes := option.Votes
+			result.Votes = &votes
+		}
+		results.Options = append(results.Options, result)
+	}
+	if results.ResultsVisible {
+		totalVoters := poll.TotalVoters
+		results.TotalVoters = &totalVoters
+	}
+	return results, nil
+}
+
+// parseVote reads the options voted for from the request body, which must be valid options of the poll
+func parseVote(c *fiber.Ctx, poll *models.Poll) ([]int, error) {
+	var body struct {
+		Options []int `json:"options"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, errInvalidVote
+	}
+	if len(body.Options) == 0 || (!poll.MultipleChoice && len(body.Options) > 1) {
+		return nil, errInvalidVote
+	}
+
+	chosen := map[int]bool{}
+	for _, option := range body.Options {
+		if option < 0 || option >= len(poll.Options) || chosen[option] {
+			return nil, errInvalidVote
+		}
+		chosen[option] = true
+	}
+	sort.Ints(body.Options)
+	return body.Options, nil
+}
+
+// VotePoll records the requesting user's vote in the poll on a post, replacing any earlier vote.
+// The option counts are adjusted atomically by the difference between the old and new vote.
+func VotePoll(c *fiber.Ctx) error {
+	// Get a handle to the posts and poll votes collections
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	votesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("poll_votes")
+
+	// Retrieve the voter and the poll
+	voter, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return pollError(c, errUnknownVoter)
+		}
+		return pollError(c, err)
+	}
+	post, err := findRequestedPoll(c)
+	if err != nil {
+		return pollError(c, err)
+	}
+	if pollClosed(post.Poll) {
+		return pollError(c, errPollClosed)
+	}
+
+	// Read the vote
+	chosen, err := parseVote(c, post.Poll)
+	if err != nil {
+		return pollError(c, err)
+	}
+
+	// Store the vote, keeping the previous one to work out how the counts change
+	now := time.Now()
+	vote := bson.M{
+		"post_id":    post.ID,
+		"username":   voter.Username,
+		"options":    chosen,
+		"updated_at": now,
+	}
+	update := bson.M{"$set": vote, "$setOnInsert": bson.M{"created_at": now}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	var previous models.PollVote
+	err = votesCollection.FindOneAndUpdate(c.Context(), bson.M{"post_id": post.ID, "username": voter.Username}, update, opts).Decode(&previous)
+	firstVote := err == mongo.ErrNoDocuments
+	if err != nil && !firstVote {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store vote in database",
+		})
+	}
+
+	// Adjust the counts of the options that were added to or removed from the vote
+	counts := bson.M{}
+	for _, option := range previous.Options {
+		counts[fmt.Sprintf("poll.options.%d.votes", option)] = -1
+	}
+	for _, option := range chosen {
+		key := fmt.Sprintf("poll.options.%d.votes", option)
+		if counts[key] == -1 {
+			delete(counts, key)
+		} else {
+			counts[key] = 1
+		}
+	}
+	if firstVote {
+		counts["poll.total_voters"] = 1
+	}
+	if len(counts) > 0 {
+		if _, err := postsCollection.UpdateOne(c.Context(), bson.M{"_id": post.ID}, bson.M{"$inc": counts}); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not update poll in database",
+			})
+		}
+	}
+
+	// Return the poll as the voter now sees it
+	results, err := pollResults(c.Context(), post, voter.Username)
+	if err != nil {
+		return pollError(c, err)
+	}
+	return c.JSON(results)
+}
+
+// GetPollResults retrieves the poll on a post as the requesting user sees it
+func GetPollResults(c *fiber.Ctx) error {
+	post, err := findRequestedPoll(c)
+	if err != nil {
+		return pollError(c, err)
+	}
+
+	results, err := pollResults(c.Context(), post, currentUsername(c))
+	if err != nil {
+		return pollError(c, err)
+	}
+	return c.JSON(results)
+}
+
+// closePoll marks the open poll on a post matching filter as closed, returning mongo.ErrNoDocuments if there is none
+func closePoll(ctx context.Context, filter bson.M) (*models.Post, error) {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	filter["poll.closed"] = false
+	var post models.Post
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := postsCollection.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"poll.closed": true}}, opts).Decode(&post); err != nil {
+		return nil, err
+	}
+	return &post, cachePost(ctx, &post)
+}
+
+// ClosePoll closes the poll on a post of the requesting user straight away
+func ClosePoll(c *fiber.Ctx) error {
+	if currentUsername(c) != c.Params("username") {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can close a poll",
+		})
+	}
+	post, err := findRequestedPoll(c)
+	if err != nil {
+		return pollError(c, err)
+	}
+
+	if _, err := closePoll(c.Context(), bson.M{"_id": post.ID}); err != nil && err != mongo.ErrNoDocuments {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update poll in database",
+		})
+	}
+
+	results, err := pollResults(c.Context(), post, post.Username)
+	if err != nil {
+		return pollError(c, err)
+	}
+	return c.JSON(results)
+}
+
+// recountPoll recomputes the option counts of the poll on a post from the stored votes
+func recountPoll(ctx context.Context, post *models.Post) error {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	votesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("poll_votes")
+
+	cursor, err := votesCollection.Find(ctx, bson.M{"post_id": post.ID})
+	if err != nil {
+		return err
+	}
+	var votes []models.PollVote
+	if err := cursor.All(ctx, &votes); err != nil {
+		return err
+	}
+
+	counts := make([]int, len(post.Poll.Options))
+	for _, vote := range votes {
+		for _, option := range vote.Options {
+			if option >= 0 && option < len(counts) {
+				counts[option]++
+			}
+		}
+	}
+	fields := bson.M{"poll.total_voters": len(votes)}
+	for option, count := range counts {
+		fields[fmt.Sprintf("poll.options.%d.votes", option)] = count
+	}
+	_, err = postsCollection.UpdateOne(ctx, bson.M{"_id": post.ID}, bson.M{"$set": fields})
+	return err
+}
+
+// closeDuePolls closes the polls whose close time has passed and notifies their authors
+func closeDuePolls(ctx context.Context) error {
+	// Only one web server closes polls per interval
+	acquired, err := acquireLock(ctx, "poll-closer", pollCloseInterval/2)
+	if err != nil || !acquired {
+		return err
+	}
+
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	ids, err := objectIDs(ctx, postsCollection, bson.M{"poll.closed": false, "poll.closes_at": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		post, err := closePoll(ctx, bson.M{"_id": id})
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("Could not close poll on post %s: %v", id.Hex(), err)
+			}
+			continue
+		}
+
+		// Notify the author that their poll has closed
+		if post.DeletedAt == nil {
+			notification := models.Notification{
+				UserID:     post.UserID,
+				Username:   post.Username,
+				Type:       models.PollClosedNotification,
+				PostID:     post.ID,
+				Recipient:  post.Username,
+				Content:    post.Content,
+				ReadStatus: false,
+				CreatedAt:  time.Now(),
+				UpdatedAt:  time.Now(),
+			}
+			sendNotification(notification, "poll")
+		}
+	}
+	return nil
+}
+
+// StartPollCloser periodically closes polls that are past their close time in the background
+func StartPollCloser() {
+	go func() {
+		ticker := time.NewTicker(pollCloseInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := closeDuePolls(context.Background()); err != nil {
+				log.Printf("Could not close polls: %v", err)
+			}
+		}
+	}()
+}
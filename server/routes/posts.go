@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"time"
 
@@ -13,20 +14,21 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
-	"github.com/alexander-winters/SENG468-A2/kafka-docker/kafkaService"
 	"github.com/alexander-winters/SENG468-A2/mymongo"
 	"github.com/alexander-winters/SENG468-A2/mymongo/models"
 )
 
 // CreatePost inserts a new post into the database
 func CreatePost(c *fiber.Ctx) error {
-	// Get a handle to the posts collection
-	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
-	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
-
-	// Get the username from the URL parameters
+	// Get the username from the URL parameters, users can only post as themselves
 	username := c.Params("username")
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only publish their own posts",
+		})
+	}
 
 	// Retrieve the user by username
 	user, err := GetUserByUsername(username)
@@ -49,91 +51,123 @@ func CreatePost(c *fiber.Ctx) error {
 		})
 	}
 
-	// Set the post number, username, and created time
-	post.PostNumber = user.PostCount + 1
-	post.Username = user.Username
-	post.Comments = []models.Comment{}
-	now := time.Now()
-	post.CreatedAt = now
-	post.UpdatedAt = now
-
-	// Insert the post into the database
-	res, err := postsCollection.InsertOne(c.Context(), post)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not insert post into database",
-		})
+	// Posts are public unless another visibility level is given, and only published to a group through the group
+	post.GroupID = primitive.NilObjectID
+	if post.Visibility == "" {
+		post.Visibility = models.PublicVisibility
 	}
-
-	// Increment the user's post count and update the user in the database
-	user.PostCount++
-	filter := bson.M{"username": username}
-	update := bson.M{"$set": bson.M{"postCount": user.PostCount}}
-
-	_, err = usersCollection.UpdateOne(c.Context(), filter, update)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not update user in database",
+	if !isVisibility(post.Visibility) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid visibility",
 		})
 	}
 
-	// Update the user in Redis cache
-	userJSONBytes, err := json.Marshal(user)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not serialize user object",
-		})
+	// Check the poll, if the post has one
+	if post.Poll != nil {
+		if err := preparePoll(post.Poll, time.Now()); err != nil {
+			return pollError(c, err)
+		}
 	}
-	userJSON := string(userJSONBytes)
 
-	err = rdb.Set(c.Context(), "user:"+user.Username, userJSON, 0).Err()
-	if err != nil {
+	// Publish the post
+	post.ID = primitive.NewObjectID()
+	if err := publishPost(c.Context(), user, &post); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not store user in Redis",
+			"error": "Could not insert post into database",
 		})
 	}
 
-	// Set the ID of the comment and return it
-	post.ID = res.InsertedID.(primitive.ObjectID)
-
-	// Get the friends of the user who created the post
-	friends, err := GetUserFriends(username)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not retrieve user friends",
-		})
-	}
+	// Return the created post
+	return c.JSON(post)
+}
 
-	// Iterate through the friends and send notifications
-	for _, friend := range friends {
-		// Create a Notification
-		notification := models.Notification{
-			UserID:     post.UserID,
-			Username:   username,
-			Type:       models.PostCreatedNotification,
-			PostID:     post.ID,
-			Recipient:  friend,
-			Content:    post.Content,
-			ReadStatus: false,
-			CreatedAt:  time.Now(),
-			UpdatedAt:  time.Now(),
-		}
+// publishPost inserts a post by a user, numbering it after the user's previous posts,
+// and notifies the user's friends and the users mentioned in it
+func publishPost(ctx context.Context, user *models.User, post *models.Post) error {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
 
-		// Initialize Kafka producer and consumer
-		kafkaProducer := kafkaService.CreateKafkaProducer(kafkaBrokerURL)
-		kafkaConsumer := kafkaService.CreateKafkaConsumer(kafkaBrokerURL, "comment")
+	// Take the next post number by incrementing the user's post count
+	var author models.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	update := bson.M{"$inc": bson.M{"post_count": 1}}
+	if err := usersCollection.FindOneAndUpdate(ctx, bson.M{"_id": user.ID}, update, opts).Decode(&author); err != nil {
+		return err
+	}
+	if err := rdb.Del(ctx, "user:"+author.Username).Err(); err != nil {
+		return err
+	}
+
+	// Set the post number, author, and published time
+	if post.ID.IsZero() {
+		post.ID = primitive.NewObjectID()
+	}
+	post.PostNumber = author.PostCount
+	post.UserID = author.ID
+	post.Username = author.Username
+	post.Status = models.PublishedPost
+	post.PublishAt = nil
+	post.DeletedAt = nil
+	post.NumberOfLikes = 0
+	post.Likes = []models.Like{}
+	post.Reactions = nil
+	post.ReactionCounts = map[models.ReactionKind]int{}
+	post.Edited = false
+	post.NumberOfEdits = 0
+	post.NumberOfComments = 0
+	post.LatestComments = []models.Comment{}
+	post.Attachments = nil
+	post.NumberOfShares = 0
+	post.Hashtags, post.Mentions = parseTags(post.Content)
+	now := time.Now()
+	post.CreatedAt = now
+	post.UpdatedAt = now
 
-		ks := kafkaService.NewKafkaService(kafkaProducer, kafkaConsumer)
+	// Insert the post into the database, giving the post number back if it fails and no later post has taken the next one
+	if _, err := postsCollection.InsertOne(ctx, post); err != nil {
+		filter := bson.M{"_id": author.ID, "post_count": author.PostCount}
+		if _, undoErr := usersCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"post_count": -1}}); undoErr != nil {
+			log.Printf("Could not give back post number %d of %s: %v", author.PostCount, author.Username, undoErr)
+		}
+		return err
+	}
 
-		// Send a notification to the post owner
-		err = ks.SendUserNotification(notification)
-		if err != nil {
-			log.Printf("Could not send notification: %v", err)
+	// Notify the other members of the group the post was published to.
+	// The post is published by now, so a failure is only logged.
+	if !post.GroupID.IsZero() {
+		if err := notifyGroupPost(ctx, &author, post); err != nil {
+			log.Printf("Could not notify the group of post %s: %v", post.ID.Hex(), err)
+		}
+		return nil
+	}
+
+	// Notify the author's friends, private posts not being announced to anyone
+	if post.Visibility != models.PrivateVisibility {
+		for _, friend := range author.ListOfFriends {
+			notification := models.Notification{
+				UserID:     author.ID,
+				Username:   author.Username,
+				Type:       models.PostCreatedNotification,
+				PostID:     post.ID,
+				Recipient:  friend,
+				Content:    post.Content,
+				ReadStatus: false,
+				CreatedAt:  time.Now(),
+				UpdatedAt:  time.Now(),
+			}
+			sendNotification(notification, "comment")
 		}
 	}
 
-	// Return the created post
-	return c.JSON(post)
+	// Notify the users mentioned in the post
+	notifyMentions(post, models.Notification{
+		UserID:   author.ID,
+		Username: author.Username,
+		PostID:   post.ID,
+		Content:  post.Content,
+	}, post.Mentions, nil)
+
+	return nil
 }
 
 // GetPostbyUsername retrieves a post by username and postNumber, first checking Redis cache, then the database
@@ -149,7 +183,7 @@ func GetPostByUsername(username string, postNumber int) (*models.Post, error) {
 	if err == redis.Nil {
 		// Post not found in Redis cache, query the database
 		var post models.Post
-		err = postsCollection.FindOne(ctx, bson.M{"username": username, "post_number": postNumber}).Decode(&post)
+		err = postsCollection.FindOne(ctx, notDeleted(bson.M{"username": username, "post_number": postNumber})).Decode(&post)
 		if err != nil {
 			return nil, err
 		}
@@ -177,6 +211,9 @@ func GetPostByUsername(username string, postNumber int) (*models.Post, error) {
 		if err != nil {
 			return nil, err
 		}
+		if post.DeletedAt != nil {
+			return nil, mongo.ErrNoDocuments
+		}
 		return &post, nil
 	}
 
@@ -194,7 +231,7 @@ func GetPost(c *fiber.Ctx) error {
 	}
 
 	// Retreive the post
-	post, err := GetPostByUsername(username, postNumber)
+	post, err := findVisiblePost(c, username, postNumber)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -209,76 +246,137 @@ func GetPost(c *fiber.Ctx) error {
 	return c.JSON(post)
 }
 
-// UpdatePost updates a post in the database by username and post number
+// UpdatePost updates the content or visibility of a post by username and post number, recording content edits as new revisions
 func UpdatePost(c *fiber.Ctx) error {
 	// Get the username and post number from the request parameters
 	username := c.Params("username")
-	postNumber, err := strconv.Atoi(c.Params("postNumber"))
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid post number",
 		})
 	}
 
-	// Parse the request body into a struct
-	var post models.Post
+	// Only the author can edit their posts
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can edit their posts",
+		})
+	}
+
+	// Parse the request body, leaving out the fields that are not given
+	var post struct {
+		Content    *string           `json:"content"`
+		Visibility models.Visibility `json:"visibility"`
+	}
 	if err := c.BodyParser(&post); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Could not parse request body",
 		})
 	}
+	if post.Content == nil && post.Visibility == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Nothing to update",
+		})
+	}
 
-	// Set the updated time
-	post.UpdatedAt = time.Now()
+	// The visibility level is only changed if one is given
+	if post.Visibility != "" && !isVisibility(post.Visibility) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid visibility",
+		})
+	}
 
 	// Get a handle to the posts collection
-	postsCollection := mymongo.GetMongoClient().Database("seng468_a2_db").Collection("posts")
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
 
-	// Retrieve the existing post
-	existingPost, err := GetPostByUsername(username, postNumber)
+	// Update the post in the database, keeping the previous version to record its revision
+	now := time.Now()
+	var hashtags, mentions []string
+	filter := notDeleted(bson.M{"username": username, "post_number": postNumber})
+	fields := bson.M{"updated_at": now}
+	update := bson.M{"$set": fields}
+	if post.Content != nil {
+		// The content is only changed, and counted as an edit, if it is given
+		hashtags, mentions = parseTags(*post.Content)
+		fields["content"] = *post.Content
+		fields["hashtags"] = hashtags
+		fields["mentions"] = mentions
+		fields["edited"] = true
+		update["$inc"] = bson.M{"number_of_edits": 1}
+	}
+	if post.Visibility != "" {
+		// The visibility of posts in a group follows the group's privacy
+		fields["visibility"] = post.Visibility
+		filter["group_id"] = bson.M{"$exists": false}
+	}
+	var existingPost models.Post
+	err = postsCollection.FindOneAndUpdate(c.Context(), filter, update).Decode(&existingPost)
 	if err != nil {
+		if err == mongo.ErrNoDocuments && post.Visibility != "" {
+			delete(filter, "group_id")
+			if count, _ := postsCollection.CountDocuments(c.Context(), filter); count > 0 {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+					"error": "The visibility of a group post follows its group",
+				})
+			}
+		}
 		if err == mongo.ErrNoDocuments {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "Post not found",
 			})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not retrieve post",
+			"error": "Could not update post in database",
 		})
 	}
 
-	// Update the post in the database
-	filter := bson.M{"username": username, "post_number": postNumber}
-	update := bson.M{"$set": bson.M{"content": existingPost.Content, "updated_at": existingPost.UpdatedAt, "likes": existingPost.Likes, "likes_count": existingPost.NumberOfLikes}}
-	_, err = postsCollection.UpdateOne(c.Context(), filter, update)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not update post in database",
-		})
+	// Record the revision of an edit to the content
+	previousMentions := existingPost.Mentions
+	if post.Content != nil {
+		err = recordRevision(c.Context(), models.PostRevision, existingPost.ID, existingPost.Content, existingPost.Username, existingPost.CreatedAt, existingPost.NumberOfEdits, *post.Content, username, now)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not store revision in database",
+			})
+		}
+
+		existingPost.Content = *post.Content
+		existingPost.Hashtags = hashtags
+		existingPost.Mentions = mentions
+		existingPost.Edited = true
+		existingPost.NumberOfEdits++
 	}
 
-	// Update the post in Redis cache
-	postKey := fmt.Sprintf("post:%s:%d", username, postNumber)
-	postJSONBytes, err := json.Marshal(existingPost)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not serialize post object",
-		})
+	// Apply the update to the post
+	if post.Visibility != "" {
+		existingPost.Visibility = post.Visibility
 	}
-	postJSON := string(postJSONBytes)
+	existingPost.UpdatedAt = now
 
-	err = rdb.Set(c.Context(), postKey, postJSON, 0).Err()
-	if err != nil {
+	// Update the post in Redis cache
+	if err := cachePost(c.Context(), &existingPost); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not store post in Redis",
 		})
 	}
 
+	// Notify the users newly mentioned by the edit
+	if post.Content != nil {
+		notifyMentions(&existingPost, models.Notification{
+			UserID:   existingPost.UserID,
+			Username: existingPost.Username,
+			PostID:   existingPost.ID,
+			Content:  existingPost.Content,
+		}, mentions, previousMentions)
+	}
+
 	// Return the updated post
 	return c.JSON(existingPost)
 }
 
-// DeletePost deletes a post from the database by username and post number
+// DeletePost moves a post, and the comments on it, to the trash by username and post number.
+// The post number stays reserved so that the post can be restored.
 func DeletePost(c *fiber.Ctx) error {
 	// Get the username and post number from the request parameters
 	username := c.Params("username")
@@ -288,61 +386,45 @@ func DeletePost(c *fiber.Ctx) error {
 			"error": "Invalid post number",
 		})
 	}
-
-	// Get handles to the posts and users collections in the database
-	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
-	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
-
-	// Delete the post from the database
-	res, err := postsCollection.DeleteOne(c.Context(), bson.M{"username": username, "post_number": postNumber})
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not delete post from database",
-		})
-	}
-
-	// Check if a document was deleted
-	if res.DeletedCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Post not found",
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can delete their posts",
 		})
 	}
 
-	// Retrieve the user and decrement the user's post count
-	user, err := GetUserByUsername(username)
+	// Retrieve the post
+	post, err := GetPostByUsername(username, postNumber)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not retrieve user",
-		})
-	}
-	user.PostCount--
-
-	// Update the user in the database
-	filter := bson.M{"username": username}
-	update := bson.M{"$set": user}
-	if _, err := usersCollection.UpdateOne(c.Context(), filter, update); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not update user in database",
+			"error": "Could not retrieve post",
 		})
 	}
 
-	// Remove the post from Redis cache
-	postKey := fmt.Sprintf("post:%s:%d", username, postNumber)
-	err = rdb.Del(c.Context(), postKey).Err()
-	if err != nil {
+	// Mark the post and its comments as deleted and remove them from Redis cache
+	if err := trashPosts(c.Context(), bson.M{"_id": post.ID}, time.Now()); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not remove post from Redis",
+			"error": "Could not delete post from database",
 		})
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "Post deleted successfully",
+		"message": "Post moved to trash",
 	})
 }
 
-func GetUserPosts(ctx context.Context, username string) ([]models.Post, error) {
+// GetUserPosts retrieves the posts of a user that viewer may see
+func GetUserPosts(ctx context.Context, username string, viewer string) ([]models.Post, error) {
 	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
-	cursor, err := postsCollection.Find(ctx, bson.M{"username": username})
+	filter, err := visiblePosts(ctx, viewer, notDeleted(bson.M{"username": username}))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := postsCollection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -352,15 +434,54 @@ func GetUserPosts(ctx context.Context, username string) ([]models.Post, error) {
 		return nil, err
 	}
 
+	// Add the posts the user shared, in the order they appeared on the user's timeline
+	shared, err := sharedPosts(ctx, bson.M{"username": username}, viewer, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(shared) > 0 {
+		posts = append(posts, shared...)
+		sort.SliceStable(posts, func(i, j int) bool {
+			return timelineTime(&posts[i]).Before(timelineTime(&posts[j]))
+		})
+	}
+
+	// Move the posts the user pinned to the front, in the order they were pinned
+	user, err := GetUserByUsername(username)
+	if err == mongo.ErrNoDocuments {
+		return posts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if len(user.PinnedPosts) == 0 {
+		return posts, nil
+	}
+	pinOrder := make(map[primitive.ObjectID]int, len(user.PinnedPosts))
+	for i, id := range user.PinnedPosts {
+		pinOrder[id] = i
+	}
+	for i := range posts {
+		if _, ok := pinOrder[posts[i].ID]; ok && posts[i].SharedBy == nil {
+			posts[i].Pinned = true
+		}
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		if posts[i].Pinned != posts[j].Pinned {
+			return posts[i].Pinned
+		}
+		return posts[i].Pinned && pinOrder[posts[i].ID] < pinOrder[posts[j].ID]
+	})
+
 	return posts, nil
 }
 
-// ListUserPosts retrieves all posts of a single user from the database by username
+// ListUserPosts retrieves all posts of a single user, along with the posts they shared, from the database by username.
+// The posts the user pinned come first.
 func ListUserPosts(c *fiber.Ctx) error {
 	// Get the username from the URL parameters
 	username := c.Params("username")
 
-	posts, err := GetUserPosts(c.Context(), username)
+	posts, err := GetUserPosts(c.Context(), username, currentUsername(c))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not retrieve posts from database",
@@ -376,9 +497,15 @@ func ListAllPosts(c *fiber.Ctx) error {
 	// Get a handle to the posts collection
 	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
 
-	// Find all posts in the database using a cursor
+	// Find all posts the requesting user may see using a cursor
 	ctx := c.Context()
-	cursor, err := postsCollection.Find(ctx, bson.M{})
+	filter, err := visiblePosts(ctx, currentUsername(c), notDeleted(bson.M{}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	cursor, err := postsCollection.Find(ctx, filter)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not retrieve posts from database",
@@ -404,102 +531,189 @@ func ListAllPosts(c *fiber.Ctx) error {
 	return c.JSON(posts)
 }
 
+// cachePost stores a post in the Redis cache under its username and post number
+func cachePost(ctx context.Context, post *models.Post) error {
+	postKey := fmt.Sprintf("post:%s:%d", post.Username, post.PostNumber)
+	postJSONBytes, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, postKey, string(postJSONBytes), 0).Err()
+}
+
+// uncachePostByID removes the post with the given ID from the Redis cache
+func uncachePostByID(ctx context.Context, postID primitive.ObjectID) error {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	var post models.Post
+	opts := options.FindOne().SetProjection(bson.M{"username": 1, "post_number": 1})
+	if err := postsCollection.FindOne(ctx, bson.M{"_id": postID}, opts).Decode(&post); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		return err
+	}
+
+	return rdb.Del(ctx, fmt.Sprintf("post:%s:%d", post.Username, post.PostNumber)).Err()
+}
+
+// LikePost adds a like from the requesting user to a post. Liking an already liked post returns the post unchanged.
 func LikePost(c *fiber.Ctx) error {
 	// Get the username and post number from the request parameters
 	username := c.Params("username")
-	postNumber, err := strconv.Atoi(c.Params("postNumber"))
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid post number",
 		})
 	}
 
-	// Get a handle to the posts collection
-	postsCollection := mymongo.GetMongoClient().Database("seng468_a2_db").Collection("posts")
-
-	// Retrieve the existing post
-	existingPost, err := GetPostByUsername(username, postNumber)
+	// Retrieve the user liking the post
+	liker, err := GetUserByUsername(currentUsername(c))
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "Post not found",
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unknown user",
 			})
 		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not retrieve post",
+			"error": "Could not retrieve user",
 		})
 	}
 
-	// Check if the user has already liked the post
-	liked := false
-	for _, like := range existingPost.Likes {
-		if like.Username == c.Locals("username").(string) {
-			liked = true
-			break
-		}
+	// Get a handle to the posts collection
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Add the like only if the user has not already liked the post
+	like := models.Like{
+		Username: liker.Username,
+		LikedAt:  time.Now(),
+	}
+	// Users can only like posts they can see
+	filter, err := visiblePosts(c.Context(), liker.Username, notDeleted(bson.M{"username": username, "post_number": postNumber, "likes.username": bson.M{"$ne": liker.Username}}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	update := bson.M{
+		"$push": bson.M{"likes": like},
+		"$inc":  bson.M{"number_of_likes": 1},
 	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-	// Add the like and increment the likes count if the user has not already liked the post
-	if !liked {
-		like := models.Like{
-			Username: c.Locals("username").(string),
-			LikedAt:  time.Now(),
+	var post models.Post
+	err = postsCollection.FindOneAndUpdate(c.Context(), filter, update, opts).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		// The post does not exist or is already liked, return its current state
+		existingPost, err := findVisiblePost(c, username, postNumber)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Post not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not retrieve post",
+			})
 		}
-		existingPost.Likes = append(existingPost.Likes, like)
-		existingPost.NumberOfLikes = existingPost.NumberOfLikes + 1
+		return c.JSON(existingPost)
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update post in database",
+		})
+	}
+
+	// Update the post in Redis cache
+	if err := cachePost(c.Context(), &post); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store post in Redis",
+		})
 	}
-	// Create a Notification
+
+	// Notify the post owner of the new like
 	notification := models.Notification{
-		UserID:     existingPost.UserID,
-		Username:   username,
-		Type:       models.PostCreatedNotification,
-		PostID:     existingPost.ID,
-		Recipient:  existingPost.Username,
-		Content:    existingPost.Content,
+		UserID:     liker.ID,
+		Username:   liker.Username,
+		Type:       models.PostLikedNotification,
+		PostID:     post.ID,
+		Recipient:  post.Username,
+		Content:    post.Content,
 		ReadStatus: false,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
+	sendNotification(notification, "like")
 
-	// Initialize Kafka producer and consumer
-	kafkaProducer := kafkaService.CreateKafkaProducer(kafkaBrokerURL)
-	kafkaConsumer := kafkaService.CreateKafkaConsumer(kafkaBrokerURL, "like")
+	// Count the like towards the post's trending ranking
+	recordEngagement(c.Context(), &post, likeEngagement)
 
-	ks := kafkaService.NewKafkaService(kafkaProducer, kafkaConsumer)
+	// Return the updated post
+	return c.JSON(post)
+}
 
-	// Send a notification to the post owner
-	err = ks.SendUserNotification(notification)
+// UnlikePost removes the requesting user's like from a post. Unliking a post that is not liked returns the post unchanged.
+func UnlikePost(c *fiber.Ctx) error {
+	// Get the username and post number from the request parameters
+	username := c.Params("username")
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
 	if err != nil {
-		log.Printf("Could not send notification: %v", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
 	}
 
-	// Update the post in the database
-	filter := bson.M{"username": username, "post_number": postNumber}
-	update := bson.M{"$set": bson.M{"likes": existingPost.Likes, "likes_count": existingPost.NumberOfLikes}}
-	_, err = postsCollection.UpdateOne(c.Context(), filter, update)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not update post in database",
+	// Get the user removing the like
+	liker := currentUsername(c)
+	if liker == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown user",
 		})
 	}
 
-	// Update the post in Redis cache
-	postKey := fmt.Sprintf("post:%s:%d", username, postNumber)
-	postJSONBytes, err := json.Marshal(existingPost)
-	if err != nil {
+	// Get a handle to the posts collection
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Remove the like only if the user has liked the post
+	filter := notDeleted(bson.M{"username": username, "post_number": postNumber, "likes.username": liker})
+	update := bson.M{
+		"$pull": bson.M{"likes": bson.M{"username": liker}},
+		"$inc":  bson.M{"number_of_likes": -1},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var post models.Post
+	err = postsCollection.FindOneAndUpdate(c.Context(), filter, update, opts).Decode(&post)
+	if err == mongo.ErrNoDocuments {
+		// The post does not exist or is not liked, return its current state
+		existingPost, err := findVisiblePost(c, username, postNumber)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+					"error": "Post not found",
+				})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not retrieve post",
+			})
+		}
+		return c.JSON(existingPost)
+	} else if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not serialize post object",
+			"error": "Could not update post in database",
 		})
 	}
-	postJSON := string(postJSONBytes)
 
-	err = rdb.Set(c.Context(), postKey, postJSON, 0).Err()
-	if err != nil {
+	// Update the post in Redis cache
+	if err := cachePost(c.Context(), &post); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not store post in Redis",
 		})
 	}
 
+	// Take the like back from the post's trending ranking
+	recordEngagement(c.Context(), &post, -likeEngagement)
+
 	// Return the updated post
-	return c.JSON(existingPost)
+	return c.JSON(post)
 }
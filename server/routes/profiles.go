@@ -0,0 +1,398 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+	"github.com/alexander-winters/SENG468-A2/storage"
+)
+
+// Limits on the length, in characters, of the free-text profile fields
+const (
+	maxBioLength      = 280
+	maxLocationLength = 100
+	maxWebsiteLength  = 200
+)
+
+// avatarSizes are the sizes, in pixels, each avatar is stored in, by name
+var avatarSizes = map[string]int{
+	"small":  48,
+	"medium": 128,
+	"large":  512,
+}
+
+// avatarSizeNames lists the avatar sizes from smallest to largest
+var avatarSizeNames = []string{"small", "medium", "large"}
+
+// avatarTypes are the image types an avatar may be uploaded in
+var avatarTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Errors returned by validateProfile for profile fields that are not valid
+var (
+	errBioTooLong      = errors.New("bio too long")
+	errLocationTooLong = errors.New("location too long")
+	errInvalidWebsite  = errors.New("invalid website")
+)
+
+// Errors returned while reading an uploaded avatar
+var (
+	errAvatarMissing = errors.New("no avatar uploaded")
+	errAvatarImage   = errors.New("avatar is not a supported image")
+)
+
+// userManagedFields are the user fields the server maintains, which a profile update may not change
+var userManagedFields = []string{"_id", "username", "post_count", "notifications", "blocked_users", "avatar", "pinned_posts", "created_at", "updated_at", "deleted_at"}
+
+// validateProfile checks the free-text profile fields of a user, requiring the website to be an http or https URL
+func validateProfile(user *models.User) error {
+	if utf8.RuneCountInString(user.Bio) > maxBioLength {
+		return errBioTooLong
+	}
+	if utf8.RuneCountInString(user.Location) > maxLocationLength {
+		return errLocationTooLong
+	}
+	if user.Website != "" {
+		website, err := url.Parse(user.Website)
+		if err != nil || (website.Scheme != "http" && website.Scheme != "https") || website.Host == "" || len(user.Website) > maxWebsiteLength {
+			return errInvalidWebsite
+		}
+	}
+	return nil
+}
+
+// profileUpdate returns the fields of a user to set in a profile update: those given in the JSON request body,
+// without the fields the server maintains
+func profileUpdate(user *models.User, body []byte) (bson.M, error) {
+	var given map[string]json.RawMessage
+	if err := json.Unmarshal(body, &given); err != nil {
+		return nil, err
+	}
+	givenKeys := map[string]bool{}
+	for key := range given {
+		givenKeys[strings.ToLower(key)] = true
+	}
+
+	data, err := bson.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	// Leave out the fields missing from the body, matching JSON keys the way encoding/json does
+	userType := reflect.TypeOf(*user)
+	for i := 0; i < userType.NumField(); i++ {
+		field := userType.Field(i)
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		if jsonName == "" {
+			jsonName = field.Name
+		}
+		if !givenKeys[strings.ToLower(jsonName)] {
+			delete(fields, strings.Split(field.Tag.Get("bson"), ",")[0])
+		}
+	}
+	for _, field := range userManagedFields {
+		delete(fields, field)
+	}
+	return fields, nil
+}
+
+// profileError writes the response for an error returned while validating a profile or reading an avatar
+func profileError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errBioTooLong:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Bio can be at most %d characters", maxBioLength),
+		})
+	case errLocationTooLong:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Location can be at most %d characters", maxLocationLength),
+		})
+	case errInvalidWebsite:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Website must be an http or https URL",
+		})
+	case errAvatarMissing:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "No avatar uploaded",
+		})
+	case errAttachmentTooLarge:
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": fmt.Sprintf("Avatars can be at most %d bytes", mediaMaxSize),
+		})
+	case errAvatarImage:
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{
+			"error": "Avatar must be a JPEG, PNG or GIF image",
+		})
+	case mongo.ErrNoDocuments:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve user",
+	})
+}
+
+// findProfileUser retrieves the user named by the username route parameter, unless they have blocked the requesting user
+func findProfileUser(c *fiber.Ctx) (*models.User, error) {
+	user, err := GetUserByUsername(c.Params("username"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Users who blocked the requesting user are reported as not found
+	if viewer := currentUsername(c); viewer != "" && viewer != user.Username {
+		for _, blocked := range user.BlockedUsers {
+			if blocked == viewer {
+				return nil, mongo.ErrNoDocuments
+			}
+		}
+	}
+	return user, nil
+}
+
+// GetProfile retrieves the public profile of a user, which leaves out private fields such as their email and date of birth
+func GetProfile(c *fiber.Ctx) error {
+	user, err := findProfileUser(c)
+	if err != nil {
+		return profileError(c, err)
+	}
+	return c.JSON(user.PublicProfile())
+}
+
+// avatarKey is the media store key of a user's avatar in the given size
+func avatarKey(userID primitive.ObjectID, size string) string {
+	return fmt.Sprintf("avatars/%s/%s.png", userID.Hex(), size)
+}
+
+// deleteAvatarFiles removes every size of a user's avatar from the media store
+func deleteAvatarFiles(ctx context.Context, user *models.User) error {
+	if user.Avatar == nil {
+		return nil
+	}
+	for _, size := range user.Avatar.Sizes {
+		if err := mediaStore.Delete(ctx, avatarKey(user.ID, size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cropSquare crops an image to the largest centred square, if the image supports it
+func cropSquare(img image.Image) image.Image {
+	cropper, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if !ok {
+		return img
+	}
+
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	x, y := bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2
+	return cropper.SubImage(image.Rect(x, y, x+side, y+side))
+}
+
+// readAvatar reads the image in the "avatar" field of a multipart form, checking its size and type
+func readAvatar(c *fiber.Ctx) (image.Image, error) {
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		return nil, errAvatarMissing
+	}
+	if header.Size > mediaMaxSize {
+		return nil, errAttachmentTooLarge
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, mediaMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > mediaMaxSize {
+		return nil, errAttachmentTooLarge
+	}
+
+	// The type is detected from the contents, and the image decoded only if it is not too large
+	mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !avatarTypes[mediaType] {
+		return nil, errAvatarImage
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > maxThumbnailPixels {
+		return nil, errAvatarImage
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errAvatarImage
+	}
+	return img, nil
+}
+
+// UploadAvatar sets the avatar of the requesting user from the image in the "avatar" field of a multipart form.
+// The image is cropped to a square and stored in each avatar size.
+func UploadAvatar(c *fiber.Ctx) error {
+	// Get a handle to the users collection
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	// Retrieve the user, who must be the requesting user
+	if currentUsername(c) != c.Params("username") {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only change their own avatar",
+		})
+	}
+	user, err := GetUserByUsername(c.Params("username"))
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	// Read the uploaded image
+	img, err := readAvatar(c)
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	// Store the avatar in each size, replacing any previous avatar
+	square := cropSquare(img)
+	for _, size := range avatarSizeNames {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scaleToFit(square, avatarSizes[size])); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not resize avatar",
+			})
+		}
+		if err := mediaStore.Put(c.Context(), avatarKey(user.ID, size), &buf); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not store avatar in media store",
+			})
+		}
+	}
+
+	// Record the avatar on the user
+	avatar := &models.Avatar{Sizes: avatarSizeNames, UpdatedAt: time.Now()}
+	if _, err := usersCollection.UpdateOne(c.Context(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"avatar": avatar}}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update user in database",
+		})
+	}
+
+	// Remove the user from Redis cache
+	if err := rdb.Del(c.Context(), "user:"+user.Username).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove user from Redis",
+		})
+	}
+
+	user.Avatar = avatar
+	return c.JSON(user.OwnerProfile())
+}
+
+// GetAvatar sends a user's avatar image.
+// The size query parameter selects "small", "medium" (the default) or "large".
+func GetAvatar(c *fiber.Ctx) error {
+	// Retrieve the user
+	user, err := findProfileUser(c)
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	size := c.Query("size", "medium")
+	if _, ok := avatarSizes[size]; !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid avatar size",
+		})
+	}
+	if user.Avatar == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User has no avatar",
+		})
+	}
+
+	// Stream the avatar from the media store
+	file, err := mediaStore.Get(c.Context(), avatarKey(user.ID, size))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User has no avatar",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve avatar",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "image/png")
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	return c.SendStream(file)
+}
+
+// DeleteAvatar removes the avatar of the requesting user
+func DeleteAvatar(c *fiber.Ctx) error {
+	// Get a handle to the users collection
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	// Retrieve the user, who must be the requesting user
+	if currentUsername(c) != c.Params("username") {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only change their own avatar",
+		})
+	}
+	user, err := GetUserByUsername(c.Params("username"))
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	// Remove the avatar from the user
+	if _, err := usersCollection.UpdateOne(c.Context(), bson.M{"_id": user.ID}, bson.M{"$unset": bson.M{"avatar": ""}}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update user in database",
+		})
+	}
+	if err := rdb.Del(c.Context(), "user:"+user.Username).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove user from Redis",
+		})
+	}
+
+	// Remove the avatar images from the media store
+	if err := deleteAvatarFiles(c.Context(), user); err != nil {
+		log.Printf("Could not remove avatar of user %s: %v", user.Username, err)
+	}
+
+	user.Avatar = nil
+	return c.JSON(user.OwnerProfile())
+}
@@ -0,0 +1,470 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// defaultReactionKinds is used when REACTION_KINDS is not set
+var defaultReactionKinds = []models.ReactionKind{"love", "laugh", "wow", "sad", "angry"}
+
+// reactionKinds holds the reaction kinds users may choose from, configured through the comma separated REACTION_KINDS environment variable
+var reactionKinds = loadReactionKinds()
+
+// errReactionConflict is returned when a reaction keeps changing concurrently while it is being updated
+var errReactionConflict = errors.New("reaction was modified concurrently")
+
+func loadReactionKinds() []models.ReactionKind {
+	value := os.Getenv("REACTION_KINDS")
+	if value == "" {
+		return defaultReactionKinds
+	}
+
+	var kinds []models.ReactionKind
+	for _, kind := range strings.Split(value, ",") {
+		if kind = strings.TrimSpace(strings.ToLower(kind)); kind != "" {
+			kinds = append(kinds, models.ReactionKind(kind))
+		}
+	}
+	return kinds
+}
+
+// isReactionKind reports whether kind is one of the configured reaction kinds
+func isReactionKind(kind models.ReactionKind) bool {
+	for _, k := range reactionKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// userReaction returns the current reaction of a user on the document with the given ID, or nil if there is none
+func userReaction(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, username string) (*models.Reaction, error) {
+	var doc struct {
+		Reactions []models.Reaction `bson:"reactions"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"reactions": bson.M{"$elemMatch": bson.M{"username": username}}})
+	if err := collection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Reactions) == 0 {
+		return nil, nil
+	}
+	return &doc.Reactions[0], nil
+}
+
+// applyReaction sets the reaction of a user on the document with the given ID, replacing any previous reaction.
+// It returns true if the user had not reacted to the document before.
+func applyReaction(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, username string, kind models.ReactionKind) (bool, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		// Add a new reaction if the user has not reacted yet
+		reaction := models.Reaction{Username: username, Kind: kind, ReactedAt: time.Now()}
+		res, err := collection.UpdateOne(ctx,
+			bson.M{"_id": id, "reactions.username": bson.M{"$ne": username}},
+			bson.M{
+				"$push": bson.M{"reactions": reaction},
+				"$inc":  bson.M{"reaction_counts." + string(kind): 1},
+			})
+		if err != nil {
+			return false, err
+		}
+		if res.ModifiedCount == 1 {
+			return true, nil
+		}
+
+		// Otherwise change the existing reaction, provided it was not changed in the meantime
+		previous, err := userReaction(ctx, collection, id, username)
+		if err != nil {
+			return false, err
+		}
+		if previous == nil {
+			continue
+		}
+		if previous.Kind == kind {
+			return false, nil
+		}
+
+		res, err = collection.UpdateOne(ctx,
+			bson.M{"_id": id, "reactions": bson.M{"$elemMatch": bson.M{"username": username, "kind": previous.Kind}}},
+			bson.M{
+				"$set": bson.M{"reactions.$.kind": kind, "reactions.$.reacted_at": reaction.ReactedAt},
+				"$inc": bson.M{"reaction_counts." + string(previous.Kind): -1, "reaction_counts." + string(kind): 1},
+			})
+		if err != nil {
+			return false, err
+		}
+		if res.ModifiedCount == 1 {
+			return false, nil
+		}
+	}
+	return false, errReactionConflict
+}
+
+// removeReaction removes the reaction of a user from the document with the given ID.
+// It returns true if a reaction was removed.
+func removeReaction(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID, username string) (bool, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		previous, err := userReaction(ctx, collection, id, username)
+		if err != nil {
+			return false, err
+		}
+		if previous == nil {
+			return false, nil
+		}
+
+		res, err := collection.UpdateOne(ctx,
+			bson.M{"_id": id, "reactions": bson.M{"$elemMatch": bson.M{"username": username, "kind": previous.Kind}}},
+			bson.M{
+				"$pull": bson.M{"reactions": bson.M{"username": username}},
+				"$inc":  bson.M{"reaction_counts." + string(previous.Kind): -1},
+			})
+		if err != nil {
+			return false, err
+		}
+		if res.ModifiedCount == 1 {
+			return true, nil
+		}
+	}
+	return false, errReactionConflict
+}
+
+// reactionBreakdown groups reactions by kind
+func reactionBreakdown(reactions []models.Reaction) models.ReactionBreakdown {
+	breakdown := models.ReactionBreakdown{
+		Counts:    make(map[models.ReactionKind]int),
+		Reactions: make(map[models.ReactionKind][]models.Reaction),
+	}
+	for _, reaction := range reactions {
+		breakdown.Counts[reaction.Kind]++
+		breakdown.Reactions[reaction.Kind] = append(breakdown.Reactions[reaction.Kind], reaction)
+	}
+	return breakdown
+}
+
+// parseReactionKind reads the reaction kind from the request body
+func parseReactionKind(c *fiber.Ctx) (models.ReactionKind, error) {
+	var body struct {
+		Kind models.ReactionKind `json:"kind"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return "", err
+	}
+	if !isReactionKind(body.Kind) {
+		return "", errors.New("unknown reaction kind")
+	}
+	return body.Kind, nil
+}
+
+// ListReactionKinds returns the reaction kinds users may choose from
+func ListReactionKinds(c *fiber.Ctx) error {
+	return c.JSON(reactionKinds)
+}
+
+// ReactToPost sets or changes the requesting user's reaction to a post
+func ReactToPost(c *fiber.Ctx) error {
+	// Get the username and post number from the request parameters
+	username := c.Params("username")
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+
+	// Parse the reaction kind from the request body
+	kind, err := parseReactionKind(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid reaction kind",
+		})
+	}
+
+	// Retrieve the user reacting to the post
+	reactor, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unknown user",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+
+	// Retrieve the post
+	post, err := findVisiblePost(c, username, postNumber)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+
+	// Set the reaction in the database
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	isNew, err := applyReaction(c.Context(), postsCollection, post.ID, reactor.Username, kind)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update post in database",
+		})
+	}
+
+	// Retrieve the updated post and store it in Redis cache
+	if err := postsCollection.FindOne(c.Context(), bson.M{"_id": post.ID}).Decode(post); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+	if err := cachePost(c.Context(), post); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store post in Redis",
+		})
+	}
+
+	// Notify the post owner the first time the user reacts
+	if isNew {
+		notification := models.Notification{
+			UserID:     reactor.ID,
+			Username:   reactor.Username,
+			Type:       models.PostReactedNotification,
+			PostID:     post.ID,
+			Recipient:  post.Username,
+			Content:    string(kind),
+			ReadStatus: false,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		sendNotification(notification, "reaction")
+	}
+
+	return c.JSON(post)
+}
+
+// RemovePostReaction removes the requesting user's reaction from a post
+func RemovePostReaction(c *fiber.Ctx) error {
+	// Get the username and post number from the request parameters
+	username := c.Params("username")
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+
+	// Get the user removing the reaction
+	reactor := currentUsername(c)
+	if reactor == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown user",
+		})
+	}
+
+	// Retrieve the post
+	post, err := findVisiblePost(c, username, postNumber)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+
+	// Remove the reaction from the database
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	removed, err := removeReaction(c.Context(), postsCollection, post.ID, reactor)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update post in database",
+		})
+	}
+	if !removed {
+		return c.JSON(post)
+	}
+
+	// Retrieve the updated post and store it in Redis cache
+	if err := postsCollection.FindOne(c.Context(), bson.M{"_id": post.ID}).Decode(post); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+	if err := cachePost(c.Context(), post); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store post in Redis",
+		})
+	}
+
+	return c.JSON(post)
+}
+
+// ListPostReactions returns who reacted to a post with what
+func ListPostReactions(c *fiber.Ctx) error {
+	// Get the username and post number from the request parameters
+	username := c.Params("username")
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+
+	// Retrieve the post
+	post, err := findVisiblePost(c, username, postNumber)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+
+	return c.JSON(reactionBreakdown(post.Reactions))
+}
+
+// ReactToComment sets or changes the requesting user's reaction to a comment
+func ReactToComment(c *fiber.Ctx) error {
+	// Parse the reaction kind from the request body
+	kind, err := parseReactionKind(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid reaction kind",
+		})
+	}
+
+	// Retrieve the user reacting to the comment
+	reactor, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unknown user",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+
+	// Retrieve the comment
+	comment, err := findRequestedComment(c)
+	if err != nil {
+		return commentLookupError(c, err)
+	}
+
+	// Set the reaction in the database
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+	isNew, err := applyReaction(c.Context(), commentsCollection, comment.ID, reactor.Username, kind)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update comment in database",
+		})
+	}
+
+	// Retrieve the updated comment and store it in Redis cache
+	if err := commentsCollection.FindOne(c.Context(), bson.M{"_id": comment.ID}).Decode(comment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve comment",
+		})
+	}
+	if err := cacheComment(c.Context(), comment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store comment in Redis",
+		})
+	}
+
+	// Notify the comment owner the first time the user reacts
+	if isNew {
+		notification := models.Notification{
+			UserID:     reactor.ID,
+			Username:   reactor.Username,
+			Type:       models.CommentReactedNotification,
+			PostID:     comment.PostID,
+			CommentID:  comment.ID,
+			Recipient:  comment.Username,
+			Content:    string(kind),
+			ReadStatus: false,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		sendNotification(notification, "reaction")
+	}
+
+	return c.JSON(comment)
+}
+
+// RemoveCommentReaction removes the requesting user's reaction from a comment
+func RemoveCommentReaction(c *fiber.Ctx) error {
+	// Get the user removing the reaction
+	reactor := currentUsername(c)
+	if reactor == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown user",
+		})
+	}
+
+	// Retrieve the comment
+	comment, err := findRequestedComment(c)
+	if err != nil {
+		return commentLookupError(c, err)
+	}
+
+	// Remove the reaction from the database
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+	removed, err := removeReaction(c.Context(), commentsCollection, comment.ID, reactor)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update comment in database",
+		})
+	}
+	if !removed {
+		return c.JSON(comment)
+	}
+
+	// Retrieve the updated comment and store it in Redis cache
+	if err := commentsCollection.FindOne(c.Context(), bson.M{"_id": comment.ID}).Decode(comment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve comment",
+		})
+	}
+	if err := cacheComment(c.Context(), comment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store comment in Redis",
+		})
+	}
+
+	return c.JSON(comment)
+}
+
+// ListCommentReactions returns who reacted to a comment with what
+func ListCommentReactions(c *fiber.Ctx) error {
+	// Retrieve the comment
+	comment, err := findRequestedComment(c)
+	if err != nil {
+		return commentLookupError(c, err)
+	}
+
+	return c.JSON(reactionBreakdown(comment.Reactions))
+}
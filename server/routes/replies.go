@@ -0,0 +1,271 @@
+package routes
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// maxReplyDepth is the deepest level a reply can be nested at, top-level comments being at depth 0
+const maxReplyDepth = 5
+
+// CreateReply inserts a reply to a comment into the database
+func CreateReply(c *fiber.Ctx) error {
+	// Get a handle to the comments collection
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	// Retrieve the comment being replied to
+	parent, err := findRequestedComment(c)
+	if err != nil {
+		return commentLookupError(c, err)
+	}
+
+	// Check the reply would not be nested too deeply
+	if parent.Depth >= maxReplyDepth {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Maximum reply depth reached",
+		})
+	}
+
+	// Retrieve the user replying
+	author, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unknown user",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+
+	// Parse the request body into a struct
+	var reply models.Comment
+	if err := c.BodyParser(&reply); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+
+	// Link the reply to its parent comment and post
+	now := time.Now()
+	reply.ID = primitive.NewObjectID()
+	reply.PostID = parent.PostID
+	reply.PostNumber = parent.PostNumber
+	reply.ParentID = parent.ID
+	reply.Depth = parent.Depth + 1
+	reply.UserID = author.ID
+	reply.Username = author.Username
+	reply.Hashtags, reply.Mentions = parseTags(reply.Content)
+	reply.CreatedAt = now
+	reply.UpdatedAt = now
+
+	// Start the reply with no likes, replies or edits
+	reply.NumberOfReplies = 0
+	reply.DeletedAt = nil
+	reply.NumberOfLikes = 0
+	reply.Likes = []models.Like{}
+	reply.Reactions = nil
+	reply.ReactionCounts = nil
+	reply.Edited = false
+	reply.NumberOfEdits = 0
+
+	// Insert the reply into the database
+	if _, err := commentsCollection.InsertOne(c.Context(), reply); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert reply into database",
+		})
+	}
+
+	// Increment the reply count of the parent comment
+	filter := bson.M{"_id": parent.ID}
+	update := bson.M{"$inc": bson.M{"number_of_replies": 1}}
+	if _, err := commentsCollection.UpdateOne(c.Context(), filter, update); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update comment in database",
+		})
+	}
+
+	// Remove the stale parent comment from Redis cache
+	if err := rdb.Del(c.Context(), "comment:"+parent.ID.Hex()).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove comment from Redis",
+		})
+	}
+
+	// Count the reply on its post
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	filter = bson.M{"_id": reply.PostID}
+	update = bson.M{"$inc": bson.M{"number_of_comments": 1}}
+	var post models.Post
+	if err := postsCollection.FindOneAndUpdate(c.Context(), filter, update).Decode(&post); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update post in database",
+		})
+	}
+	if err := uncachePostByID(c.Context(), reply.PostID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove post from Redis",
+		})
+	}
+
+	// Notify the author of the parent comment, if they may still see the post
+	parentCanView, err := canViewPost(&post, parent.Username)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	if parent.Username != author.Username && parentCanView {
+		notification := models.Notification{
+			UserID:     author.ID,
+			Username:   author.Username,
+			Type:       models.CommentRepliedNotification,
+			PostID:     reply.PostID,
+			CommentID:  reply.ID,
+			Recipient:  parent.Username,
+			Content:    reply.Content,
+			ReadStatus: false,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		}
+		sendNotification(notification, "comment")
+	}
+
+	// Notify the users mentioned in the reply, apart from the parent's author who was already notified
+	notifyMentions(&post, models.Notification{
+		UserID:    author.ID,
+		Username:  author.Username,
+		PostID:    reply.PostID,
+		CommentID: reply.ID,
+		Content:   reply.Content,
+	}, reply.Mentions, []string{parent.Username})
+
+	// Count the reply towards the post's trending ranking
+	recordEngagement(c.Context(), &post, commentEngagement)
+
+	return c.JSON(reply)
+}
+
+// findReplies retrieves a page of the direct replies to a comment, oldest first.
+// It also reports whether the comment has more replies after this page.
+func findReplies(ctx context.Context, parent *models.Comment, skip, limit int) ([]models.Comment, bool, error) {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+	cursor, err := commentsCollection.Find(ctx, notDeleted(bson.M{"parent_id": parent.ID}), opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var replies []models.Comment
+	if err := cursor.All(ctx, &replies); err != nil {
+		return nil, false, err
+	}
+
+	return replies, skip+len(replies) < parent.NumberOfReplies, nil
+}
+
+// buildReplyTree nests the first limit replies below each of the given replies to parent, down to the given number of levels.
+// The deeper replies are loaded with a single query on the post and arranged in memory.
+func buildReplyTree(ctx context.Context, parent *models.Comment, replies []models.Comment, levels, limit int) ([]models.CommentThread, error) {
+	children := map[primitive.ObjectID][]models.Comment{}
+	if levels > 1 && len(replies) > 0 {
+		commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+		filter := notDeleted(bson.M{
+			"post_id": parent.PostID,
+			"depth":   bson.M{"$gt": parent.Depth + 1, "$lte": parent.Depth + levels},
+		})
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+		cursor, err := commentsCollection.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, err
+		}
+		var descendants []models.Comment
+		if err := cursor.All(ctx, &descendants); err != nil {
+			return nil, err
+		}
+		for _, descendant := range descendants {
+			children[descendant.ParentID] = append(children[descendant.ParentID], descendant)
+		}
+	}
+	return nestReplies(replies, children, levels, limit), nil
+}
+
+// nestReplies arranges replies into threads, taking the replies below each from children, grouped by parent comment
+func nestReplies(replies []models.Comment, children map[primitive.ObjectID][]models.Comment, levels, limit int) []models.CommentThread {
+	threads := make([]models.CommentThread, 0, len(replies))
+	for _, reply := range replies {
+		thread := models.CommentThread{Comment: reply, Replies: []models.CommentThread{}}
+
+		if levels > 1 {
+			below := children[reply.ID]
+			thread.HasMoreReplies = len(below) > limit
+			if len(below) > limit {
+				below = below[:limit]
+			}
+			thread.Replies = nestReplies(below, children, levels-1, limit)
+		} else {
+			thread.HasMoreReplies = reply.NumberOfReplies > 0
+		}
+
+		threads = append(threads, thread)
+	}
+	return threads
+}
+
+// GetReplies retrieves the reply tree of a comment.
+// The page and limit query parameters page through the direct replies, deeper levels include their first limit replies.
+// The levels query parameter sets how many levels of replies are included.
+func GetReplies(c *fiber.Ctx) error {
+	// Retrieve the comment
+	parent, err := findRequestedComment(c)
+	if err != nil {
+		return commentLookupError(c, err)
+	}
+
+	// Read the pagination and depth parameters
+	page, limit := pageParams(c)
+	levels := c.QueryInt("levels", 1)
+	if levels < 1 {
+		levels = 1
+	}
+	if levels > maxReplyDepth {
+		levels = maxReplyDepth
+	}
+
+	// Find the requested page of direct replies
+	replies, hasMore, err := findReplies(c.Context(), parent, (page-1)*limit, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve replies from database",
+		})
+	}
+
+	// Expand the deeper levels of the tree
+	tree, err := buildReplyTree(c.Context(), parent, replies, levels, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve replies from database",
+		})
+	}
+
+	return c.JSON(models.CommentThread{
+		Comment:        *parent,
+		Replies:        tree,
+		HasMoreReplies: hasMore,
+	})
+}
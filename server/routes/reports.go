@@ -31,7 +31,7 @@ func PostReport(c *fiber.Ctx) error {
 	// Concurrently find the user document that matches the username
 	go func() {
 		var user models.User
-		err := usersCollection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+		err := usersCollection.FindOne(ctx, notDeleted(bson.M{"username": username})).Decode(&user)
 		if err != nil {
 			errChan <- err
 			return
@@ -53,9 +53,9 @@ func PostReport(c *fiber.Ctx) error {
 	// Create the pipeline for the aggregation query
 	pipeline := bson.A{
 		bson.M{
-			"$match": bson.M{
+			"$match": notDeleted(bson.M{
 				"user_id": userID,
-			},
+			}),
 		},
 		bson.M{
 			"$group": bson.M{
@@ -132,7 +132,7 @@ func UserCommentReport(c *fiber.Ctx) error {
 	// Concurrently find the user in the users collection
 	go func() {
 		var user models.User
-		err := usersCollection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+		err := usersCollection.FindOne(ctx, notDeleted(bson.M{"username": username})).Decode(&user)
 		if err != nil {
 			errChan <- err
 			return
@@ -156,7 +156,7 @@ func UserCommentReport(c *fiber.Ctx) error {
 
 	// Concurrently find all comments created by the user and store them in a slice
 	go func() {
-		cursor, err := commentsCollection.Find(ctx, bson.M{"user_id": userID})
+		cursor, err := commentsCollection.Find(ctx, notDeleted(bson.M{"user_id": userID}))
 		if err != nil {
 			errChan <- err
 			return
@@ -212,7 +212,7 @@ func LikeReport(c *fiber.Ctx) error {
 	// Concurrently find the user in the users collection
 	go func() {
 		var user models.User
-		err := usersCollection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+		err := usersCollection.FindOne(ctx, notDeleted(bson.M{"username": username})).Decode(&user)
 		if err != nil {
 			errChan <- err
 			return
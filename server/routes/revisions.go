@@ -0,0 +1,220 @@
+package routes
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// recordRevision stores the content of a post or comment after an edit.
+// previousEdits is the number of edits before this one; on the first edit the original content is stored as revision 0 as well.
+func recordRevision(ctx context.Context, target models.RevisionTarget, targetID primitive.ObjectID, original, author string, createdAt time.Time, previousEdits int, content, editor string, editedAt time.Time) error {
+	revisionsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("revisions")
+
+	var revisions []interface{}
+	if previousEdits == 0 {
+		revisions = append(revisions, models.Revision{
+			TargetType: target,
+			TargetID:   targetID,
+			Number:     0,
+			Content:    original,
+			Editor:     author,
+			EditedAt:   createdAt,
+		})
+	}
+	revisions = append(revisions, models.Revision{
+		TargetType: target,
+		TargetID:   targetID,
+		Number:     previousEdits + 1,
+		Content:    content,
+		Editor:     editor,
+		EditedAt:   editedAt,
+	})
+
+	_, err := revisionsCollection.InsertMany(ctx, revisions)
+	return err
+}
+
+// findRevisions retrieves the revisions of a post or comment, oldest first.
+// A document that was never edited has a single revision holding its current content.
+func findRevisions(ctx context.Context, target models.RevisionTarget, targetID primitive.ObjectID, content, author string, createdAt time.Time) ([]models.Revision, error) {
+	revisionsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("revisions")
+
+	opts := options.Find().SetSort(bson.D{{Key: "number", Value: 1}})
+	cursor, err := revisionsCollection.Find(ctx, bson.M{"target_type": target, "target_id": targetID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []models.Revision
+	if err := cursor.All(ctx, &revisions); err != nil {
+		return nil, err
+	}
+
+	if len(revisions) == 0 {
+		revisions = append(revisions, models.Revision{
+			TargetType: target,
+			TargetID:   targetID,
+			Number:     0,
+			Content:    content,
+			Editor:     author,
+			EditedAt:   createdAt,
+		})
+	}
+	return revisions, nil
+}
+
+// findRevision retrieves a single revision of a post or comment by number
+func findRevision(ctx context.Context, target models.RevisionTarget, targetID primitive.ObjectID, number int, content, author string, createdAt time.Time) (*models.Revision, error) {
+	revisions, err := findRevisions(ctx, target, targetID, content, author, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		if revision.Number == number {
+			return &revision, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// ListPostRevisions retrieves all revisions of a post by username and post number
+func ListPostRevisions(c *fiber.Ctx) error {
+	// Get the username and post number from the request parameters
+	username := c.Params("username")
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+
+	// Retrieve the post
+	post, err := findVisiblePost(c, username, postNumber)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+
+	// Retrieve the revisions of the post
+	revisions, err := findRevisions(c.Context(), models.PostRevision, post.ID, post.Content, post.Username, post.CreatedAt)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve revisions from database",
+		})
+	}
+
+	return c.JSON(revisions)
+}
+
+// GetPostRevision retrieves a single revision of a post by username, post number and revision number
+func GetPostRevision(c *fiber.Ctx) error {
+	// Get the username, post number and revision number from the request parameters
+	username := c.Params("username")
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+	revisionNumber, err := strconv.Atoi(c.Params("revision_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid revision number",
+		})
+	}
+
+	// Retrieve the post
+	post, err := findVisiblePost(c, username, postNumber)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+
+	// Retrieve the revision
+	revision, err := findRevision(c.Context(), models.PostRevision, post.ID, revisionNumber, post.Content, post.Username, post.CreatedAt)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Revision not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve revision from database",
+		})
+	}
+
+	return c.JSON(revision)
+}
+
+// ListCommentRevisions retrieves all revisions of a comment by its ID
+func ListCommentRevisions(c *fiber.Ctx) error {
+	// Retrieve the comment
+	comment, err := findRequestedComment(c)
+	if err != nil {
+		return commentLookupError(c, err)
+	}
+
+	// Retrieve the revisions of the comment
+	revisions, err := findRevisions(c.Context(), models.CommentRevision, comment.ID, comment.Content, comment.Username, comment.CreatedAt)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve revisions from database",
+		})
+	}
+
+	return c.JSON(revisions)
+}
+
+// GetCommentRevision retrieves a single revision of a comment by its ID and revision number
+func GetCommentRevision(c *fiber.Ctx) error {
+	// Get the revision number from the request parameters
+	revisionNumber, err := strconv.Atoi(c.Params("revision_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid revision number",
+		})
+	}
+
+	// Retrieve the comment
+	comment, err := findRequestedComment(c)
+	if err != nil {
+		return commentLookupError(c, err)
+	}
+
+	// Retrieve the revision
+	revision, err := findRevision(c.Context(), models.CommentRevision, comment.ID, revisionNumber, comment.Content, comment.Username, comment.CreatedAt)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Revision not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve revision from database",
+		})
+	}
+
+	return c.JSON(revision)
+}
@@ -0,0 +1,115 @@
+package routes
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/search"
+)
+
+// searchBackend runs searches, backed by the MongoDB text indexes
+var searchBackend search.Backend = search.NewMongoBackend(mymongo.GetMongoClient().Database("seng468-a2-db"))
+
+// parseSearchTime reads a time query parameter given as RFC 3339 or as a date.
+// A date given as the end of a range includes that whole day.
+func parseSearchTime(c *fiber.Ctx, name string, end bool) (*time.Time, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, true
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, true
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, false
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, true
+}
+
+// Search finds users, posts and comments matching the q query parameter, most relevant first.
+// The type query parameter limits results to users, posts or comments, author limits posts and comments to a username,
+// and from and to limit results to those created in a time range.
+func Search(c *fiber.Ctx) error {
+	// Read the search query
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Missing search query",
+		})
+	}
+	query := search.Query{Text: text, Author: c.Query("author"), Viewer: currentUsername(c)}
+
+	// Read the type filter
+	if value := c.Query("type"); value != "" {
+		valid := false
+		for _, t := range search.Types {
+			if search.Type(value) == t {
+				valid = true
+			}
+		}
+		if !valid {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid search type",
+			})
+		}
+		query.Types = []search.Type{search.Type(value)}
+	}
+
+	// Read the date range
+	var ok bool
+	if query.From, ok = parseSearchTime(c, "from", false); !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid from date",
+		})
+	}
+	if query.To, ok = parseSearchTime(c, "to", true); !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid to date",
+		})
+	}
+
+	// Read the pagination parameters
+	page, limit := pageParams(c)
+	query.Skip = (page - 1) * limit
+	query.Limit = limit
+
+	// Find whose friends-only posts the requesting user may see, who has blocked them, and which groups they belong to
+	friends, err := friendsOf(c.Context(), query.Viewer)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	query.FriendsOf = friends
+	blockers, err := blockedBy(c.Context(), query.Viewer)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve blocked users",
+		})
+	}
+	query.BlockedBy = blockers
+	groups, err := groupsOf(c.Context(), query.Viewer)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user groups",
+		})
+	}
+	query.Groups = groups
+
+	// Run the search
+	results, err := searchBackend.Search(c.Context(), query)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not run search",
+		})
+	}
+
+	return c.JSON(results)
+}
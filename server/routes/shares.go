@@ -0,0 +1,391 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// maxShareCommentLength is the longest comment, in characters, a share can have
+const maxShareCommentLength = 280
+
+// errUnknownSharer is returned when the requesting user sharing a post does not exist
+var errUnknownSharer = errors.New("unknown sharing user")
+
+// timelineTime is when a post appeared on a timeline, which for a shared post is when it was shared
+func timelineTime(post *models.Post) time.Time {
+	if post.SharedBy != nil {
+		return post.SharedBy.CreatedAt
+	}
+	return post.CreatedAt
+}
+
+// sharedPosts retrieves the posts shared in the shares matching filter that viewer may see, each with the share attached.
+// Shares of posts that were deleted since are left out.
+func sharedPosts(ctx context.Context, filter bson.M, viewer string, opts *options.FindOptions) ([]models.Post, error) {
+	sharesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("shares")
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Find the shares
+	cursor, err := sharesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var shares []models.Share
+	if err := cursor.All(ctx, &shares); err != nil {
+		return nil, err
+	}
+	if len(shares) == 0 {
+		return []models.Post{}, nil
+	}
+
+	// Load the shared posts the viewer may see
+	ids := make([]primitive.ObjectID, 0, len(shares))
+	for _, share := range shares {
+		ids = append(ids, share.PostID)
+	}
+	postsFilter, err := visiblePosts(ctx, viewer, notDeleted(bson.M{"_id": bson.M{"$in": ids}}))
+	if err != nil {
+		return nil, err
+	}
+	cursor, err = postsCollection.Find(ctx, postsFilter)
+	if err != nil {
+		return nil, err
+	}
+	var posts []models.Post
+	if err := cursor.All(ctx, &posts); err != nil {
+		return nil, err
+	}
+	found := map[primitive.ObjectID]models.Post{}
+	for _, post := range posts {
+		found[post.ID] = post
+	}
+
+	// Attach each share to a copy of its post, keeping the order of the shares
+	shared := make([]models.Post, 0, len(shares))
+	for i := range shares {
+		post, ok := found[shares[i].PostID]
+		if !ok {
+			continue
+		}
+		post.SharedBy = &shares[i]
+		shared = append(shared, post)
+	}
+	return shared, nil
+}
+
+// findSharedPost retrieves the user sharing a post and the post being shared from the request
+func findSharedPost(c *fiber.Ctx) (*models.User, *models.Post, error) {
+	sharer, err := GetUserByUsername(currentUsername(c))
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, errUnknownSharer
+		}
+		return nil, nil, err
+	}
+
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return nil, nil, mongo.ErrNoDocuments
+	}
+	post, err := findVisiblePost(c, c.Params("username"), postNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sharer, post, nil
+}
+
+// adjustShareCount changes the share count of a post by delta and returns the updated post
+func adjustShareCount(ctx context.Context, postID primitive.ObjectID, delta int) (*models.Post, error) {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	var post models.Post
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	update := bson.M{"$inc": bson.M{"number_of_shares": delta}}
+	if err := postsCollection.FindOneAndUpdate(ctx, bson.M{"_id": postID}, update, opts).Decode(&post); err != nil {
+		return nil, err
+	}
+	return &post, cachePost(ctx, &post)
+}
+
+// SharePost shares a post the requesting user may see to their own timeline, with an optional comment.
+// Each user can share a post once, and the post's author is notified.
+func SharePost(c *fiber.Ctx) error {
+	// Get a handle to the shares collection
+	sharesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("shares")
+
+	// Parse the request body into a struct
+	var body struct {
+		Comment string `json:"comment"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Could not parse request body",
+			})
+		}
+	}
+	if utf8.RuneCountInString(body.Comment) > maxShareCommentLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Share comments can be at most %d characters", maxShareCommentLength),
+		})
+	}
+
+	// Retrieve the sharing user and the post
+	sharer, post, err := findSharedPost(c)
+	if err != nil {
+		if err == errUnknownSharer {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unknown user",
+			})
+		}
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+	if sharer.Username == post.Username {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Users cannot share their own posts",
+		})
+	}
+
+	// Record the share
+	share := models.Share{
+		ID:         primitive.NewObjectID(),
+		UserID:     sharer.ID,
+		Username:   sharer.Username,
+		PostID:     post.ID,
+		PostAuthor: post.Username,
+		PostNumber: post.PostNumber,
+		Comment:    body.Comment,
+		CreatedAt:  time.Now(),
+	}
+	if _, err := sharesCollection.InsertOne(c.Context(), share); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "Post already shared",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert share into database",
+		})
+	}
+
+	// Count the share on the post
+	updatedPost, err := adjustShareCount(c.Context(), post.ID, 1)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update post in database",
+		})
+	}
+
+	// Notify the post's author of the share
+	notification := models.Notification{
+		UserID:     sharer.ID,
+		Username:   sharer.Username,
+		Type:       models.PostSharedNotification,
+		PostID:     post.ID,
+		Recipient:  post.Username,
+		Content:    share.Comment,
+		ReadStatus: false,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	sendNotification(notification, "share")
+
+	// Count the share towards the post's trending ranking
+	recordEngagement(c.Context(), post, shareEngagement)
+
+	updatedPost.SharedBy = &share
+	return c.JSON(updatedPost)
+}
+
+// UnsharePost removes the requesting user's share of a post
+func UnsharePost(c *fiber.Ctx) error {
+	// Get a handle to the shares collection
+	sharesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("shares")
+
+	// Retrieve the sharing user and the post
+	sharer, post, err := findSharedPost(c)
+	if err != nil {
+		if err == errUnknownSharer {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unknown user",
+			})
+		}
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+
+	// Remove the share
+	res, err := sharesCollection.DeleteOne(c.Context(), bson.M{"username": sharer.Username, "post_id": post.ID})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete share from database",
+		})
+	}
+	if res.DeletedCount == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Post not shared",
+		})
+	}
+
+	// Stop counting the share on the post
+	updatedPost, err := adjustShareCount(c.Context(), post.ID, -1)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update post in database",
+		})
+	}
+
+	return c.JSON(updatedPost)
+}
+
+// ListPostShares retrieves the shares of a post the requesting user may see, newest first
+func ListPostShares(c *fiber.Ctx) error {
+	// Get a handle to the shares collection
+	sharesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("shares")
+
+	// Retrieve the post
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+	post, err := findVisiblePost(c, c.Params("username"), postNumber)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+
+	// Find the requested page of shares
+	page, limit := pageParams(c)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := sharesCollection.Find(c.Context(), bson.M{"post_id": post.ID}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve shares from database",
+		})
+	}
+	shares := []models.Share{}
+	if err := cursor.All(c.Context(), &shares); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve shares from database",
+		})
+	}
+
+	return c.JSON(shares)
+}
+
+// GetFeed retrieves the requesting user's feed: the posts written and shared by their friends, newest first
+func GetFeed(c *fiber.Ctx) error {
+	// Get a handle to the posts collection
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Retrieve the user, who must be the requesting user
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Feeds are only available to their owner",
+		})
+	}
+	user, err := GetUserByUsername(username)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+	friends := user.ListOfFriends
+	if friends == nil {
+		friends = []string{}
+	}
+
+	// Take enough of the newest posts and shares to fill the requested page from either, then merge them
+	page, limit := pageParams(c)
+	newest := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(int64(page * limit))
+
+	filter, err := visiblePosts(c.Context(), username, notDeleted(bson.M{"username": bson.M{"$in": friends}}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	cursor, err := postsCollection.Find(c.Context(), filter, newest)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+	feed := []models.Post{}
+	if err := cursor.All(c.Context(), &feed); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+
+	shared, err := sharedPosts(c.Context(), bson.M{"username": bson.M{"$in": friends}}, username, newest)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve shares from database",
+		})
+	}
+	feed = append(feed, shared...)
+	sort.SliceStable(feed, func(i, j int) bool {
+		return timelineTime(&feed[i]).After(timelineTime(&feed[j]))
+	})
+
+	// Return the requested page
+	start := (page - 1) * limit
+	if start < 0 {
+		start = 0
+	}
+	if start > len(feed) {
+		start = len(feed)
+	}
+	end := start + limit
+	if end > len(feed) {
+		end = len(feed)
+	}
+	return c.JSON(feed[start:end])
+}
@@ -0,0 +1,476 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// maxStoryLength is the longest content, in characters, a story can have
+const maxStoryLength = 500
+
+// storyLifetime is how long a story can be seen before it expires, configured through STORY_LIFETIME
+var storyLifetime = durationFromEnv("STORY_LIFETIME", 24*time.Hour)
+
+// Errors returned while finding or changing stories
+var (
+	errStoryContent     = errors.New("invalid story content")
+	errStoryNotFound    = errors.New("story not found")
+	errNotStoryAuthor   = errors.New("not the story author")
+	errUnknownStoryUser = errors.New("unknown user")
+)
+
+// storyError writes the response for an error returned while finding or changing stories
+func storyError(c *fiber.Ctx, err error) error {
+	switch err {
+	case errStoryContent:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": fmt.Sprintf("Stories must be 1 to %d characters", maxStoryLength),
+		})
+	case errStoryNotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Story not found",
+		})
+	case errNotStoryAuthor:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can do this",
+		})
+	case errUnknownStoryUser:
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unknown user",
+		})
+	case mongo.ErrNoDocuments:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Could not retrieve stories",
+	})
+}
+
+// storyKey is the Redis cache key of a story
+func storyKey(id primitive.ObjectID) string {
+	return "story:" + id.Hex()
+}
+
+// cacheStory stores a story in Redis cache until it expires, so the cache entry disappears along with the story
+func cacheStory(ctx context.Context, story *models.Story) error {
+	ttl := time.Until(story.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	storyJSON, err := json.Marshal(story)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, storyKey(story.ID), string(storyJSON), ttl).Err()
+}
+
+// getStory retrieves a story that has not expired, first checking Redis cache, then the database.
+// Stories past their expiry are reported as not found even before the TTL index removes them.
+func getStory(ctx context.Context, id primitive.ObjectID) (*models.Story, error) {
+	storiesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("stories")
+
+	var story models.Story
+	storyJSON, err := rdb.Get(ctx, storyKey(id)).Result()
+	if err == redis.Nil {
+		filter := bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now()}}
+		if err := storiesCollection.FindOne(ctx, filter).Decode(&story); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, errStoryNotFound
+			}
+			return nil, err
+		}
+		if err := cacheStory(ctx, &story); err != nil {
+			return nil, err
+		}
+		return &story, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(storyJSON), &story); err != nil {
+		return nil, err
+	}
+	if !story.ExpiresAt.After(time.Now()) {
+		return nil, errStoryNotFound
+	}
+	return &story, nil
+}
+
+// canViewStories reports whether viewer may see an author's stories: the author's own, or those of an author who has viewer as a friend
+// and has not blocked them
+func canViewStories(author string, viewer string) (bool, error) {
+	if viewer == "" {
+		return false, nil
+	}
+	if viewer == author {
+		return true, nil
+	}
+	user, err := GetUserByUsername(author)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, blocked := range user.BlockedUsers {
+		if blocked == viewer {
+			return false, nil
+		}
+	}
+	for _, friend := range user.ListOfFriends {
+		if friend == viewer {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// findViewableStory retrieves the story named by the story_id route parameter if the requesting user may see it.
+// Stories hidden from the requesting user are reported as not found.
+func findViewableStory(c *fiber.Ctx) (*models.Story, error) {
+	id, err := primitive.ObjectIDFromHex(c.Params("story_id"))
+	if err != nil {
+		return nil, errStoryNotFound
+	}
+	story, err := getStory(c.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	visible, err := canViewStories(story.Username, currentUsername(c))
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, errStoryNotFound
+	}
+	return story, nil
+}
+
+// viewedStories returns which of the given stories viewer has seen
+func viewedStories(ctx context.Context, viewer string, ids []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+	viewsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("story_views")
+
+	viewed := map[primitive.ObjectID]bool{}
+	if viewer == "" || len(ids) == 0 {
+		return viewed, nil
+	}
+	storyIDs, err := viewsCollection.Distinct(ctx, "story_id", bson.M{"username": viewer, "story_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range storyIDs {
+		if storyID, ok := id.(primitive.ObjectID); ok {
+			viewed[storyID] = true
+		}
+	}
+	return viewed, nil
+}
+
+// activeStories finds the stories matching filter that have not expired, oldest first,
+// marking those viewer has seen; a viewer's own stories count as seen
+func activeStories(ctx context.Context, filter bson.M, viewer string) ([]models.Story, error) {
+	storiesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("stories")
+
+	filter["expires_at"] = bson.M{"$gt": time.Now()}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := storiesCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	stories := []models.Story{}
+	if err := cursor.All(ctx, &stories); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(stories))
+	for _, story := range stories {
+		ids = append(ids, story.ID)
+	}
+	viewed, err := viewedStories(ctx, viewer, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range stories {
+		stories[i].Viewed = viewed[stories[i].ID] || stories[i].Username == viewer
+	}
+	return stories, nil
+}
+
+// recordStoryView records the first time viewer sees a story, counting the view on the story
+func recordStoryView(ctx context.Context, story *models.Story, viewer string) error {
+	storiesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("stories")
+	viewsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("story_views")
+
+	filter := bson.M{"story_id": story.ID, "username": viewer}
+	update := bson.M{"$setOnInsert": bson.M{"viewed_at": time.Now(), "expires_at": story.ExpiresAt}}
+	res, err := viewsCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	if res.UpsertedCount == 0 {
+		return nil
+	}
+
+	if _, err := storiesCollection.UpdateOne(ctx, bson.M{"_id": story.ID}, bson.M{"$inc": bson.M{"number_of_views": 1}}); err != nil {
+		return err
+	}
+	story.NumberOfViews++
+	return rdb.Del(ctx, storyKey(story.ID)).Err()
+}
+
+// deleteStories removes the given stories along with the record of who viewed them
+func deleteStories(ctx context.Context, ids []primitive.ObjectID) error {
+	database := mymongo.GetMongoClient().Database("seng468-a2-db")
+
+	if len(ids) == 0 {
+		return nil
+	}
+	if _, err := database.Collection("story_views").DeleteMany(ctx, bson.M{"story_id": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+	if _, err := database.Collection("stories").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, storyKey(id))
+	}
+	return rdb.Del(ctx, keys...).Err()
+}
+
+// CreateStory posts a story by the requesting user, which their friends can see until it expires
+func CreateStory(c *fiber.Ctx) error {
+	// Get a handle to the stories collection
+	storiesCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("stories")
+
+	// Retrieve the author
+	if currentUsername(c) != c.Params("username") {
+		return storyError(c, errNotStoryAuthor)
+	}
+	author, err := GetUserByUsername(c.Params("username"))
+	if err != nil {
+		return storyError(c, err)
+	}
+
+	// Parse the request body into a struct
+	var body struct {
+		Content string `json:"content"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+	content := strings.TrimSpace(body.Content)
+	if content == "" || utf8.RuneCountInString(content) > maxStoryLength {
+		return storyError(c, errStoryContent)
+	}
+
+	// Insert the story, which the TTL index removes once it expires
+	now := time.Now()
+	story := models.Story{
+		ID:        primitive.NewObjectID(),
+		UserID:    author.ID,
+		Username:  author.Username,
+		Content:   content,
+		CreatedAt: now,
+		ExpiresAt: now.Add(storyLifetime),
+		Viewed:    true,
+	}
+	if _, err := storiesCollection.InsertOne(c.Context(), story); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not insert story into database",
+		})
+	}
+	if err := cacheStory(c.Context(), &story); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not store story in Redis cache",
+		})
+	}
+
+	return c.JSON(story)
+}
+
+// ListUserStories retrieves the active stories of a user, oldest first, if the requesting user may see them
+func ListUserStories(c *fiber.Ctx) error {
+	// Retrieve the author
+	author, err := findProfileUser(c)
+	if err != nil {
+		return profileError(c, err)
+	}
+
+	// Only the author and their friends see their stories
+	viewer := currentUsername(c)
+	visible, err := canViewStories(author.Username, viewer)
+	if err != nil {
+		return storyError(c, err)
+	}
+	if !visible {
+		return c.JSON([]models.Story{})
+	}
+
+	stories, err := activeStories(c.Context(), bson.M{"username": author.Username}, viewer)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve stories from database",
+		})
+	}
+	return c.JSON(stories)
+}
+
+// GetStoryFeed retrieves the active stories of the requesting user's friends, grouped by author.
+// Authors with stories the user has not seen come first, then the authors who posted most recently.
+func GetStoryFeed(c *fiber.Ctx) error {
+	// Retrieve the user
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return storyError(c, errNotStoryAuthor)
+	}
+	if _, err := GetUserByUsername(username); err != nil {
+		return storyError(c, err)
+	}
+
+	// Find the authors who have the user as a friend and have not blocked them
+	authors, err := friendsOf(c.Context(), username)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	blockers, err := blockedBy(c.Context(), username)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve blocked users",
+		})
+	}
+	filter := bson.M{"username": bson.M{"$in": authors, "$nin": blockers}}
+
+	// Find their stories and group them by author
+	stories, err := activeStories(c.Context(), filter, username)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve stories from database",
+		})
+	}
+	feed := []models.AuthorStories{}
+	index := map[string]int{}
+	for _, story := range stories {
+		i, ok := index[story.Username]
+		if !ok {
+			i = len(feed)
+			index[story.Username] = i
+			feed = append(feed, models.AuthorStories{Username: story.Username, AllViewed: true})
+		}
+		feed[i].Stories = append(feed[i].Stories, story)
+		feed[i].AllViewed = feed[i].AllViewed && story.Viewed
+	}
+	sort.SliceStable(feed, func(i, j int) bool {
+		if feed[i].AllViewed != feed[j].AllViewed {
+			return !feed[i].AllViewed
+		}
+		latest := func(group models.AuthorStories) time.Time {
+			return group.Stories[len(group.Stories)-1].CreatedAt
+		}
+		return latest(feed[i]).After(latest(feed[j]))
+	})
+
+	return c.JSON(feed)
+}
+
+// GetStory retrieves a story the requesting user may see, recording that they viewed it
+func GetStory(c *fiber.Ctx) error {
+	story, err := findViewableStory(c)
+	if err != nil {
+		return storyError(c, err)
+	}
+
+	// Views by the author are not recorded
+	viewer := currentUsername(c)
+	if viewer != story.Username {
+		if err := recordStoryView(c.Context(), story, viewer); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Could not record story view",
+			})
+		}
+	}
+
+	story.Viewed = true
+	return c.JSON(story)
+}
+
+// ListStoryViewers retrieves a page of the users who viewed one of the requesting user's stories, most recent first
+func ListStoryViewers(c *fiber.Ctx) error {
+	// Get a handle to the story views collection
+	viewsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("story_views")
+
+	// Retrieve the story
+	story, err := findViewableStory(c)
+	if err != nil {
+		return storyError(c, err)
+	}
+	if story.Username != currentUsername(c) {
+		return storyError(c, errNotStoryAuthor)
+	}
+
+	// Find the requested page of views
+	page, limit := pageParams(c)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "viewed_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := viewsCollection.Find(c.Context(), bson.M{"story_id": story.ID}, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve story views from database",
+		})
+	}
+	views := []models.StoryView{}
+	if err := cursor.All(c.Context(), &views); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve story views from database",
+		})
+	}
+	return c.JSON(views)
+}
+
+// DeleteStory removes one of the requesting user's stories before it expires
+func DeleteStory(c *fiber.Ctx) error {
+	// Retrieve the story
+	story, err := findViewableStory(c)
+	if err != nil {
+		return storyError(c, err)
+	}
+	if story.Username != currentUsername(c) {
+		return storyError(c, errNotStoryAuthor)
+	}
+
+	// Delete the story, its views and its cache entry
+	if err := deleteStories(c.Context(), []primitive.ObjectID{story.ID}); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not delete story from database",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Story deleted",
+	})
+}
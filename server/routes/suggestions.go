@@ -0,0 +1,354 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// Weights of the signals friend suggestions are ranked by
+const (
+	mutualFriendWeight = 10
+	interactionWeight  = 1
+)
+
+// maxSuggestions is the most friend suggestions kept for a user
+const maxSuggestions = 50
+
+// maxMutualFriendNames is how many of the friends in common are named on a suggestion
+const maxMutualFriendNames = 3
+
+// suggestionRefreshInterval is how often everyone's friend suggestions are recomputed, configured through SUGGESTION_REFRESH_INTERVAL
+var suggestionRefreshInterval = durationFromEnv("SUGGESTION_REFRESH_INTERVAL", time.Hour)
+
+// suggestionCacheTTL is how long computed friend suggestions are cached, configured through SUGGESTION_CACHE_TTL.
+// It outlasts the refresh interval so suggestions are recomputed before they lapse.
+var suggestionCacheTTL = durationFromEnv("SUGGESTION_CACHE_TTL", 6*time.Hour)
+
+// suggestionsKey is the Redis cache key of a user's friend suggestions
+func suggestionsKey(username string) string {
+	return "suggestions:" + username
+}
+
+// countInteractions runs an aggregation that yields a username and count per document, adding the counts up by username
+func countInteractions(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline, counts map[string]int) error {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var docs []struct {
+		Username string `bson:"_id"`
+		Count    int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		counts[doc.Username] += doc.Count
+	}
+	return nil
+}
+
+// interactionCounts counts, for each candidate, the likes and comments they and username left on each other's posts
+func interactionCounts(ctx context.Context, username string, candidates []string) (map[string]int, error) {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	counts := map[string]int{}
+	count := bson.M{"$sum": 1}
+
+	// Likes the user left on the candidates' posts
+	err := countInteractions(ctx, postsCollection, mongo.Pipeline{
+		{{Key: "$match", Value: notDeleted(bson.M{"username": bson.M{"$in": candidates}, "likes.username": username})}},
+		{{Key: "$group", Value: bson.M{"_id": "$username", "count": count}}},
+	}, counts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Likes the candidates left on the user's posts
+	err = countInteractions(ctx, postsCollection, mongo.Pipeline{
+		{{Key: "$match", Value: notDeleted(bson.M{"username": username, "likes.username": bson.M{"$in": candidates}})}},
+		{{Key: "$unwind", Value: "$likes"}},
+		{{Key: "$match", Value: bson.M{"likes.username": bson.M{"$in": candidates}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$likes.username", "count": count}}},
+	}, counts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Comments the user left on the candidates' posts
+	err = countInteractions(ctx, commentsCollection, mongo.Pipeline{
+		{{Key: "$match", Value: notDeleted(bson.M{"username": username})}},
+		{{Key: "$lookup", Value: bson.M{"from": postsCollection.Name(), "localField": "post_id", "foreignField": "_id", "as": "post"}}},
+		{{Key: "$unwind", Value: "$post"}},
+		{{Key: "$match", Value: bson.M{"post.username": bson.M{"$in": candidates}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$post.username", "count": count}}},
+	}, counts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Comments the candidates left on the user's posts
+	postIDs, err := objectIDs(ctx, postsCollection, notDeleted(bson.M{"username": username}))
+	if err != nil {
+		return nil, err
+	}
+	if len(postIDs) > 0 {
+		err = countInteractions(ctx, commentsCollection, mongo.Pipeline{
+			{{Key: "$match", Value: notDeleted(bson.M{"post_id": bson.M{"$in": postIDs}, "username": bson.M{"$in": candidates}})}},
+			{{Key: "$group", Value: bson.M{"_id": "$username", "count": count}}},
+		}, counts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return counts, nil
+}
+
+// excludedFromSuggestions returns the users who should not be suggested to user: themselves, their friends,
+// those who listed them as a friend and are waiting for them to do the same, and those either of them has blocked
+func excludedFromSuggestions(ctx context.Context, user *models.User) (map[string]bool, error) {
+	excluded := map[string]bool{user.Username: true}
+	for _, friend := range user.ListOfFriends {
+		excluded[friend] = true
+	}
+	for _, blocked := range user.BlockedUsers {
+		excluded[blocked] = true
+	}
+
+	pending, err := friendsOf(ctx, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	blockers, err := blockedBy(ctx, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range append(pending, blockers...) {
+		excluded[username] = true
+	}
+	return excluded, nil
+}
+
+// computeSuggestions ranks the friends of a user's friends by the friends they have in common with the user,
+// then by how much the two have interacted through likes and comments
+func computeSuggestions(ctx context.Context, user *models.User) ([]models.FriendSuggestion, error) {
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	suggestions := []models.FriendSuggestion{}
+	if len(user.ListOfFriends) == 0 {
+		return suggestions, nil
+	}
+	excluded, err := excludedFromSuggestions(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the friends of the user's friends, with the friends in common
+	cursor, err := usersCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: notDeleted(bson.M{"username": bson.M{"$in": user.ListOfFriends}})}},
+		{{Key: "$unwind", Value: "$list_of_friends"}},
+		{{Key: "$group", Value: bson.M{"_id": "$list_of_friends", "mutual": bson.M{"$addToSet": "$username"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var friendsOfFriends []struct {
+		Username string   `bson:"_id"`
+		Mutual   []string `bson:"mutual"`
+	}
+	if err := cursor.All(ctx, &friendsOfFriends); err != nil {
+		return nil, err
+	}
+	mutual := map[string][]string{}
+	candidates := []string{}
+	for _, candidate := range friendsOfFriends {
+		if !excluded[candidate.Username] {
+			mutual[candidate.Username] = candidate.Mutual
+			candidates = append(candidates, candidate.Username)
+		}
+	}
+	if len(candidates) == 0 {
+		return suggestions, nil
+	}
+
+	// Retrieve the candidates, leaving out deleted users
+	cursor, err = usersCollection.Find(ctx, notDeleted(bson.M{"username": bson.M{"$in": candidates}}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+	interactions, err := interactionCounts(ctx, user.Username, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	// Score and rank the candidates
+	for i := range users {
+		names := mutual[users[i].Username]
+		sort.Strings(names)
+		suggestion := models.FriendSuggestion{
+			Profile:           users[i].PublicProfile(),
+			MutualFriends:     len(names),
+			MutualFriendNames: names,
+			Interactions:      interactions[users[i].Username],
+		}
+		if len(names) > maxMutualFriendNames {
+			suggestion.MutualFriendNames = names[:maxMutualFriendNames]
+		}
+		suggestion.Score = suggestion.MutualFriends*mutualFriendWeight + suggestion.Interactions*interactionWeight
+		suggestions = append(suggestions, suggestion)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		if suggestions[i].MutualFriends != suggestions[j].MutualFriends {
+			return suggestions[i].MutualFriends > suggestions[j].MutualFriends
+		}
+		return suggestions[i].Profile.Username < suggestions[j].Profile.Username
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	return suggestions, nil
+}
+
+// refreshUserSuggestions computes a user's friend suggestions and stores them in Redis cache
+func refreshUserSuggestions(ctx context.Context, user *models.User) ([]models.FriendSuggestion, error) {
+	suggestions, err := computeSuggestions(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	suggestionsJSON, err := json.Marshal(suggestions)
+	if err != nil {
+		return nil, err
+	}
+	if err := rdb.Set(ctx, suggestionsKey(user.Username), string(suggestionsJSON), suggestionCacheTTL).Err(); err != nil {
+		return nil, err
+	}
+	return suggestions, nil
+}
+
+// refreshSuggestions recomputes the cached friend suggestions of every user who has friends
+func refreshSuggestions(ctx context.Context) error {
+	// Only one web server recomputes suggestions per interval
+	acquired, err := acquireLock(ctx, "friend-suggestions", suggestionRefreshInterval/2)
+	if err != nil || !acquired {
+		return err
+	}
+
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+	cursor, err := usersCollection.Find(ctx, notDeleted(bson.M{"list_of_friends.0": bson.M{"$exists": true}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return err
+		}
+		if _, err := refreshUserSuggestions(ctx, &user); err != nil {
+			log.Printf("Could not compute friend suggestions for %s: %v", user.Username, err)
+		}
+	}
+	return cursor.Err()
+}
+
+// StartSuggestionRefresher periodically recomputes everyone's friend suggestions in the background
+func StartSuggestionRefresher() {
+	go func() {
+		ticker := time.NewTicker(suggestionRefreshInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := refreshSuggestions(context.Background()); err != nil {
+				log.Printf("Could not refresh friend suggestions: %v", err)
+			}
+		}
+	}()
+}
+
+// ListFriendSuggestions retrieves a page of the people the requesting user may know, best match first.
+// Suggestions are served from Redis cache, computed on first request and recomputed periodically;
+// users who became friends or were blocked since are left out straight away.
+func ListFriendSuggestions(c *fiber.Ctx) error {
+	// Retrieve the user
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Friend suggestions are only available to their owner",
+		})
+	}
+	user, err := GetUserByUsername(username)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+
+	// Read the suggestions from Redis cache, computing them if they are not cached
+	var suggestions []models.FriendSuggestion
+	suggestionsJSON, err := rdb.Get(c.Context(), suggestionsKey(username)).Result()
+	if err == redis.Nil {
+		suggestions, err = refreshUserSuggestions(c.Context(), user)
+	} else if err == nil {
+		err = json.Unmarshal([]byte(suggestionsJSON), &suggestions)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not compute friend suggestions",
+		})
+	}
+
+	// Leave out the users who no longer qualify
+	excluded, err := excludedFromSuggestions(c.Context(), user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	current := make([]models.FriendSuggestion, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		if !excluded[suggestion.Profile.Username] {
+			current = append(current, suggestion)
+		}
+	}
+
+	// Return the requested page
+	page, limit := pageParams(c)
+	start := (page - 1) * limit
+	if start < 0 {
+		start = 0
+	}
+	if start > len(current) {
+		start = len(current)
+	}
+	end := start + limit
+	if end > len(current) {
+		end = len(current)
+	}
+	return c.JSON(current[start:end])
+}
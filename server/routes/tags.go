@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// hashtagPattern matches #hashtags that start a word, such as "#golang" but not "a#b"
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&])#([\p{L}\p{N}_]+)`)
+
+// mentionPattern matches @mentions of usernames that start a word, such as "@alice" but not "bob@example.com"
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.])@([A-Za-z0-9_]+)`)
+
+// normalizeTag lowercases a hashtag and strips its leading "#", so tags match regardless of case
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// parseTags extracts the distinct hashtags and mentions from content, in the order they first appear
+func parseTags(content string) ([]string, []string) {
+	hashtags := []string{}
+	seenTags := map[string]bool{}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(content, -1) {
+		tag := normalizeTag(match[1])
+		if !seenTags[tag] {
+			seenTags[tag] = true
+			hashtags = append(hashtags, tag)
+		}
+	}
+
+	mentions := []string{}
+	seenMentions := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		if !seenMentions[match[1]] {
+			seenMentions[match[1]] = true
+			mentions = append(mentions, match[1])
+		}
+	}
+
+	return hashtags, mentions
+}
+
+// notifyMentions sends a mention notification to each mentioned user who exists and may see the post,
+// apart from the author of the notification and the users listed in skip
+func notifyMentions(post *models.Post, notification models.Notification, mentions []string, skip []string) {
+	skipped := map[string]bool{notification.Username: true}
+	for _, username := range skip {
+		skipped[username] = true
+	}
+
+	for _, username := range mentions {
+		if skipped[username] {
+			continue
+		}
+		if _, err := GetUserByUsername(username); err != nil {
+			continue
+		}
+		if visible, err := canViewPost(post, username); err != nil || !visible {
+			continue
+		}
+
+		notification.Type = models.MentionedNotification
+		notification.Recipient = username
+		notification.ReadStatus = false
+		notification.CreatedAt = time.Now()
+		notification.UpdatedAt = time.Now()
+		sendNotification(notification, "mention")
+	}
+}
+
+// ListTaggedPosts retrieves the posts with a hashtag, newest first
+func ListTaggedPosts(c *fiber.Ctx) error {
+	// Get a handle to the posts collection
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Get the tag from the request parameters
+	tag := normalizeTag(c.Params("tag"))
+	if tag == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid tag",
+		})
+	}
+
+	// Find the requested page of tagged posts the requesting user may see
+	filter, err := visiblePosts(c.Context(), currentUsername(c), notDeleted(bson.M{"hashtags": tag}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	page, limit := pageParams(c)
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((page - 1) * limit)).
+		SetLimit(int64(limit))
+	cursor, err := postsCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+
+	posts := []models.Post{}
+	if err := cursor.All(c.Context(), &posts); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+
+	return c.JSON(posts)
+}
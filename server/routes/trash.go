@@ -0,0 +1,826 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// trashRetention is how long deleted users, posts and comments can be restored before they are purged, configured through TRASH_RETENTION
+var trashRetention = durationFromEnv("TRASH_RETENTION", 30*24*time.Hour)
+
+// trashPurgeInterval is how often expired items are purged from the trash, configured through TRASH_PURGE_INTERVAL
+var trashPurgeInterval = durationFromEnv("TRASH_PURGE_INTERVAL", time.Hour)
+
+// durationFromEnv reads a duration such as "24h" from an environment variable, falling back to a default if it is unset or invalid
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// notDeleted adds a condition to a filter that excludes soft-deleted documents
+func notDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// objectIDs returns the IDs of the documents matching filter
+func objectIDs(ctx context.Context, collection *mongo.Collection, filter bson.M) ([]primitive.ObjectID, error) {
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids, nil
+}
+
+// commentKeys returns the Redis cache keys of the given comments
+func commentKeys(ids []primitive.ObjectID) []string {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, "comment:"+id.Hex())
+	}
+	return keys
+}
+
+// commentSubtree returns the IDs of all replies below a comment that match the extra conditions
+func commentSubtree(ctx context.Context, rootID primitive.ObjectID, extra bson.M) ([]primitive.ObjectID, error) {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	var subtree []primitive.ObjectID
+	frontier := []primitive.ObjectID{rootID}
+	for len(frontier) > 0 {
+		filter := bson.M{"parent_id": bson.M{"$in": frontier}}
+		for key, value := range extra {
+			filter[key] = value
+		}
+
+		children, err := objectIDs(ctx, commentsCollection, filter)
+		if err != nil {
+			return nil, err
+		}
+		subtree = append(subtree, children...)
+		frontier = children
+	}
+	return subtree, nil
+}
+
+// trashPosts marks the live posts matching filter, and the live comments on them, as deleted at the given time
+func trashPosts(ctx context.Context, filter bson.M, deletedAt time.Time) error {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	// Find the live posts
+	cursor, err := postsCollection.Find(ctx, notDeleted(filter), options.Find().SetProjection(bson.M{"username": 1, "post_number": 1}))
+	if err != nil {
+		return err
+	}
+	var posts []models.Post
+	if err := cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+	if len(posts) == 0 {
+		return nil
+	}
+
+	postIDs := make([]primitive.ObjectID, 0, len(posts))
+	keys := make([]string, 0, len(posts))
+	for _, post := range posts {
+		postIDs = append(postIDs, post.ID)
+		keys = append(keys, fmt.Sprintf("post:%s:%d", post.Username, post.PostNumber))
+	}
+
+	// Mark the posts and their comments as deleted
+	commentIDs, err := objectIDs(ctx, commentsCollection, notDeleted(bson.M{"post_id": bson.M{"$in": postIDs}}))
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$set": bson.M{"deleted_at": deletedAt}}
+	if _, err := postsCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": postIDs}}, update); err != nil {
+		return err
+	}
+	if len(commentIDs) > 0 {
+		if _, err := commentsCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": commentIDs}}, update); err != nil {
+			return err
+		}
+	}
+
+	// Unpin the posts from their authors' profiles
+	usernames, err := unpinPosts(ctx, postIDs)
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		keys = append(keys, "user:"+username, username)
+	}
+
+	// Remove the posts and comments from Redis cache
+	keys = append(keys, commentKeys(commentIDs)...)
+	return rdb.Del(ctx, keys...).Err()
+}
+
+// restorePosts restores the posts matching filter that were deleted at the given time, together with the comments deleted with them
+func restorePosts(ctx context.Context, filter bson.M, deletedAt time.Time) error {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	filter["deleted_at"] = deletedAt
+	postIDs, err := objectIDs(ctx, postsCollection, filter)
+	if err != nil {
+		return err
+	}
+	if len(postIDs) == 0 {
+		return nil
+	}
+
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}}
+	if _, err := postsCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": postIDs}}, update); err != nil {
+		return err
+	}
+	_, err = commentsCollection.UpdateMany(ctx, bson.M{"post_id": bson.M{"$in": postIDs}, "deleted_at": deletedAt}, update)
+	return err
+}
+
+// trashComment marks a live comment and the live replies below it that match the replies filter as deleted at the given time
+func trashComment(ctx context.Context, comment *models.Comment, deletedAt time.Time, replies bson.M) error {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	// Mark the comment as deleted, unless it already is
+	update := bson.M{"$set": bson.M{"deleted_at": deletedAt}}
+	res, err := commentsCollection.UpdateOne(ctx, notDeleted(bson.M{"_id": comment.ID}), update)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return nil
+	}
+
+	// Mark the replies below it as deleted
+	replyIDs, err := commentSubtree(ctx, comment.ID, notDeleted(replies))
+	if err != nil {
+		return err
+	}
+	if len(replyIDs) > 0 {
+		if _, err := commentsCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": replyIDs}}, update); err != nil {
+			return err
+		}
+	}
+
+	// Uncount the comment and its replies
+	if err := adjustCommentCounts(ctx, comment, -(1 + len(replyIDs))); err != nil {
+		return err
+	}
+
+	// Remove the comments from Redis cache
+	keys := commentKeys(append(replyIDs, comment.ID))
+	return rdb.Del(ctx, keys...).Err()
+}
+
+// restoreComment restores a deleted comment together with the replies deleted with it
+func restoreComment(ctx context.Context, comment *models.Comment) error {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	// Restore the comment, unless it was restored in the meantime
+	deletedAt := *comment.DeletedAt
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}}
+	res, err := commentsCollection.UpdateOne(ctx, bson.M{"_id": comment.ID, "deleted_at": deletedAt}, update)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return nil
+	}
+
+	// Restore the replies deleted with it
+	replyIDs, err := commentSubtree(ctx, comment.ID, bson.M{"deleted_at": deletedAt})
+	if err != nil {
+		return err
+	}
+	if len(replyIDs) > 0 {
+		if _, err := commentsCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": replyIDs}}, update); err != nil {
+			return err
+		}
+	}
+
+	// Count the comment and its replies again
+	return adjustCommentCounts(ctx, comment, 1+len(replyIDs))
+}
+
+// adjustCommentCounts updates the counters affected by a comment and its replies being removed or restored,
+// delta being the number of comments that were restored or, if negative, removed
+func adjustCommentCounts(ctx context.Context, comment *models.Comment, delta int) error {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Update the comment count of the post
+	if _, err := postsCollection.UpdateOne(ctx, bson.M{"_id": comment.PostID}, bson.M{"$inc": bson.M{"number_of_comments": delta}}); err != nil {
+		return err
+	}
+
+	if comment.ParentID.IsZero() {
+		// Top-level comments may be among the post's previews
+		if err := refreshLatestComments(ctx, comment.PostID); err != nil {
+			return err
+		}
+	} else {
+		// Replies are counted on their parent comment
+		step := 1
+		if delta < 0 {
+			step = -1
+		}
+		if _, err := commentsCollection.UpdateOne(ctx, bson.M{"_id": comment.ParentID}, bson.M{"$inc": bson.M{"number_of_replies": step}}); err != nil {
+			return err
+		}
+		if err := rdb.Del(ctx, "comment:"+comment.ParentID.Hex()).Err(); err != nil {
+			return err
+		}
+	}
+
+	return uncachePostByID(ctx, comment.PostID)
+}
+
+// trashUser marks a user, their posts and their comments as deleted at the given time
+func trashUser(ctx context.Context, user *models.User, deletedAt time.Time) error {
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	// Mark the user as deleted
+	update := bson.M{"$set": bson.M{"deleted_at": deletedAt}}
+	if _, err := usersCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
+		return err
+	}
+
+	// Mark the user's posts and the comments on them as deleted
+	if err := trashPosts(ctx, bson.M{"username": user.Username}, deletedAt); err != nil {
+		return err
+	}
+
+	// Mark the user's remaining comments as deleted, outermost first so that replies are counted once.
+	// Replies other users left below them are not the user's to take along.
+	opts := options.Find().SetSort(bson.D{{Key: "depth", Value: 1}})
+	cursor, err := commentsCollection.Find(ctx, notDeleted(bson.M{"username": user.Username}), opts)
+	if err != nil {
+		return err
+	}
+	var comments []models.Comment
+	if err := cursor.All(ctx, &comments); err != nil {
+		return err
+	}
+	for i := range comments {
+		if err := trashComment(ctx, &comments[i], deletedAt, bson.M{"username": user.Username}); err != nil {
+			return err
+		}
+	}
+
+	// Remove the user from Redis cache
+	return rdb.Del(ctx, "user:"+user.Username, user.Username).Err()
+}
+
+// restoreUser restores a deleted user together with the posts and comments deleted with them
+func restoreUser(ctx context.Context, user *models.User) error {
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	// Restore the user
+	deletedAt := *user.DeletedAt
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}}
+	if _, err := usersCollection.UpdateOne(ctx, bson.M{"_id": user.ID, "deleted_at": deletedAt}, update); err != nil {
+		return err
+	}
+
+	// Restore the user's posts and the comments on them
+	if err := restorePosts(ctx, bson.M{"username": user.Username}, deletedAt); err != nil {
+		return err
+	}
+
+	// Restore the user's remaining comments, outermost first
+	opts := options.Find().SetSort(bson.D{{Key: "depth", Value: 1}})
+	cursor, err := commentsCollection.Find(ctx, bson.M{"username": user.Username, "deleted_at": deletedAt}, opts)
+	if err != nil {
+		return err
+	}
+	var comments []models.Comment
+	if err := cursor.All(ctx, &comments); err != nil {
+		return err
+	}
+	for i := range comments {
+		restorable, err := commentRestorable(ctx, &comments[i])
+		if err != nil {
+			return err
+		}
+		if !restorable {
+			continue
+		}
+		if err := restoreComment(ctx, &comments[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commentRestorable reports whether the post and parent comment of a deleted comment are live, so that it can be restored
+func commentRestorable(ctx context.Context, comment *models.Comment) (bool, error) {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	count, err := postsCollection.CountDocuments(ctx, notDeleted(bson.M{"_id": comment.PostID}))
+	if err != nil || count == 0 {
+		return false, err
+	}
+	if !comment.ParentID.IsZero() {
+		count, err = commentsCollection.CountDocuments(ctx, notDeleted(bson.M{"_id": comment.ParentID}))
+		if err != nil || count == 0 {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// inRetention reports whether an item deleted at the given time can still be restored
+func inRetention(deletedAt *time.Time) bool {
+	return deletedAt != nil && time.Since(*deletedAt) < trashRetention
+}
+
+// deleteComments permanently removes comments along with their revisions, notifications and cache entries
+func deleteComments(ctx context.Context, ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	database := mymongo.GetMongoClient().Database("seng468-a2-db")
+
+	if _, err := database.Collection("revisions").DeleteMany(ctx, bson.M{"target_type": models.CommentRevision, "target_id": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+	if _, err := database.Collection("notifications").DeleteMany(ctx, bson.M{"comment_id": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+	if _, err := database.Collection("comments").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return err
+	}
+	return rdb.Del(ctx, commentKeys(ids)...).Err()
+}
+
+// liftReplies moves the replies to a comment that is being removed up to its parent comment, or to the top level,
+// so that they stay reachable in the thread. The replies below them move up a level with them.
+func liftReplies(ctx context.Context, comment *models.Comment) error {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	replyIDs, err := objectIDs(ctx, commentsCollection, bson.M{"parent_id": comment.ID})
+	if err != nil || len(replyIDs) == 0 {
+		return err
+	}
+	subtree, err := commentSubtree(ctx, comment.ID, bson.M{})
+	if err != nil {
+		return err
+	}
+
+	// Move the replies and the replies below them up a level
+	if _, err := commentsCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": subtree}}, bson.M{"$inc": bson.M{"depth": -1}}); err != nil {
+		return err
+	}
+	update := bson.M{"$unset": bson.M{"parent_id": ""}}
+	if !comment.ParentID.IsZero() {
+		update = bson.M{"$set": bson.M{"parent_id": comment.ParentID}}
+	}
+	if _, err := commentsCollection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": replyIDs}}, update); err != nil {
+		return err
+	}
+	if err := rdb.Del(ctx, commentKeys(subtree)...).Err(); err != nil {
+		return err
+	}
+
+	// Count the live replies on their new parent, or among the post's previews if they are now top-level comments
+	if comment.ParentID.IsZero() {
+		if err := refreshLatestComments(ctx, comment.PostID); err != nil {
+			return err
+		}
+		return uncachePostByID(ctx, comment.PostID)
+	}
+	live, err := commentsCollection.CountDocuments(ctx, notDeleted(bson.M{"_id": bson.M{"$in": replyIDs}}))
+	if err != nil {
+		return err
+	}
+	if _, err := commentsCollection.UpdateOne(ctx, bson.M{"_id": comment.ParentID}, bson.M{"$inc": bson.M{"number_of_replies": live}}); err != nil {
+		return err
+	}
+	return rdb.Del(ctx, "comment:"+comment.ParentID.Hex()).Err()
+}
+
+// removeComments permanently removes comments, deepest first, lifting the replies left below each of them to its parent
+func removeComments(ctx context.Context, comments []models.Comment) error {
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].Depth > comments[j].Depth
+	})
+	for i := range comments {
+		if err := liftReplies(ctx, &comments[i]); err != nil {
+			return err
+		}
+		if err := deleteComments(ctx, []primitive.ObjectID{comments[i].ID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeComment permanently removes a comment and the replies below it that are in the trash, keeping live replies
+func purgeComment(ctx context.Context, commentID primitive.ObjectID) error {
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	replyIDs, err := commentSubtree(ctx, commentID, bson.M{"deleted_at": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	cursor, err := commentsCollection.Find(ctx, bson.M{"_id": bson.M{"$in": append(replyIDs, commentID)}})
+	if err != nil {
+		return err
+	}
+	var comments []models.Comment
+	if err := cursor.All(ctx, &comments); err != nil {
+		return err
+	}
+	return removeComments(ctx, comments)
+}
+
+// purgePost permanently removes a post along with its comments, revisions, notifications, attachments and cache entries
+func purgePost(ctx context.Context, post *models.Post) error {
+	database := mymongo.GetMongoClient().Database("seng468-a2-db")
+
+	// Remove the files attached to the post
+	if err := deleteAttachmentFiles(ctx, post.ID, post.Attachments); err != nil {
+		return err
+	}
+
+	// Remove the comments on the post
+	commentIDs, err := objectIDs(ctx, database.Collection("comments"), bson.M{"post_id": post.ID})
+	if err != nil {
+		return err
+	}
+	if err := deleteComments(ctx, commentIDs); err != nil {
+		return err
+	}
+
+	// Remove the revisions, shares, poll votes, bookmarks and notifications of the post
+	if _, err := database.Collection("shares").DeleteMany(ctx, bson.M{"post_id": post.ID}); err != nil {
+		return err
+	}
+	if _, err := database.Collection("poll_votes").DeleteMany(ctx, bson.M{"post_id": post.ID}); err != nil {
+		return err
+	}
+	if _, err := database.Collection("bookmarks").DeleteMany(ctx, bson.M{"post_id": post.ID}); err != nil {
+		return err
+	}
+	if _, err := database.Collection("revisions").DeleteMany(ctx, bson.M{"target_type": models.PostRevision, "target_id": post.ID}); err != nil {
+		return err
+	}
+	if _, err := database.Collection("notifications").DeleteMany(ctx, bson.M{"post_id": post.ID}); err != nil {
+		return err
+	}
+
+	// Remove the post itself, unpinning it from its author's profile
+	if _, err := database.Collection("posts").DeleteOne(ctx, bson.M{"_id": post.ID}); err != nil {
+		return err
+	}
+	keys := []string{fmt.Sprintf("post:%s:%d", post.Username, post.PostNumber)}
+	usernames, err := unpinPosts(ctx, []primitive.ObjectID{post.ID})
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		keys = append(keys, "user:"+username, username)
+	}
+	return rdb.Del(ctx, keys...).Err()
+}
+
+// purgeUser permanently removes a user along with everything tied to them
+func purgeUser(ctx context.Context, user *models.User) error {
+	return deleteAccount(ctx, user, false, nil)
+}
+
+// purgeExpiredTrash permanently removes the users, posts and comments whose retention window has passed
+func purgeExpiredTrash(ctx context.Context) error {
+	database := mymongo.GetMongoClient().Database("seng468-a2-db")
+
+	// Only one web server purges per interval
+	acquired, err := acquireLock(ctx, "trash-purge", trashPurgeInterval/2)
+	if err != nil || !acquired {
+		return err
+	}
+
+	expired := bson.M{"deleted_at": bson.M{"$lt": time.Now().Add(-trashRetention)}}
+
+	// Purge expired users
+	cursor, err := database.Collection("users").Find(ctx, expired)
+	if err != nil {
+		return err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+	for i := range users {
+		if err := purgeUser(ctx, &users[i]); err != nil {
+			return err
+		}
+	}
+
+	// Purge expired posts
+	cursor, err = database.Collection("posts").Find(ctx, expired)
+	if err != nil {
+		return err
+	}
+	var posts []models.Post
+	if err := cursor.All(ctx, &posts); err != nil {
+		return err
+	}
+	for i := range posts {
+		if err := purgePost(ctx, &posts[i]); err != nil {
+			return err
+		}
+	}
+
+	// Purge expired comments
+	commentIDs, err := objectIDs(ctx, database.Collection("comments"), expired)
+	if err != nil {
+		return err
+	}
+	for _, id := range commentIDs {
+		if err := purgeComment(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Purged %d users, %d posts and %d comments from the trash", len(users), len(posts), len(commentIDs))
+	return nil
+}
+
+// StartTrashPurge periodically purges expired items from the trash in the background
+func StartTrashPurge() {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := purgeExpiredTrash(context.Background()); err != nil {
+				log.Printf("Could not purge trash: %v", err)
+			}
+		}
+	}()
+}
+
+// ListTrash retrieves the deleted posts and comments of a user that can still be restored
+func ListTrash(c *fiber.Ctx) error {
+	// Get handles to the posts and comments collections
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	// Get the username from the URL parameters, the trash is only visible to its owner
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only see their own trash",
+		})
+	}
+
+	// Find the deleted items still within the retention window, most recently deleted first
+	filter := bson.M{"username": username, "deleted_at": bson.M{"$gte": time.Now().Add(-trashRetention)}}
+	opts := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}})
+
+	cursor, err := postsCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+	posts := []models.Post{}
+	if err := cursor.All(c.Context(), &posts); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not decode posts from cursor",
+		})
+	}
+
+	cursor, err = commentsCollection.Find(c.Context(), filter, opts)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve comments from database",
+		})
+	}
+	comments := []models.Comment{}
+	if err := cursor.All(c.Context(), &comments); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not decode comments from cursor",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"posts":     posts,
+		"comments":  comments,
+		"retention": trashRetention.String(),
+	})
+}
+
+// RestoreUser restores a deleted user, with the posts and comments deleted with them, within the retention window
+func RestoreUser(c *fiber.Ctx) error {
+	// Get a handle to the users collection
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	// Get the username from the URL parameters, users can only restore their own account
+	username := c.Params("username")
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only restore their own account",
+		})
+	}
+
+	// Find the most recently deleted user with this username
+	var user models.User
+	opts := options.FindOne().SetSort(bson.D{{Key: "deleted_at", Value: -1}})
+	err := usersCollection.FindOne(c.Context(), bson.M{"username": username, "deleted_at": bson.M{"$exists": true}}, opts).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found in trash",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+	if !inRetention(user.DeletedAt) {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "User can no longer be restored",
+		})
+	}
+
+	// Make sure the username was not taken in the meantime
+	count, err := usersCollection.CountDocuments(c.Context(), notDeleted(bson.M{"username": username}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Username is already taken",
+		})
+	}
+
+	// Restore the user
+	if err := restoreUser(c.Context(), &user); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not restore user",
+		})
+	}
+
+	user.DeletedAt = nil
+	return c.JSON(user.OwnerProfile())
+}
+
+// RestorePost restores a deleted post, with the comments deleted with it, within the retention window
+func RestorePost(c *fiber.Ctx) error {
+	// Get a handle to the posts collection
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	// Get the username and post number from the request parameters
+	username := c.Params("username")
+	postNumber, err := strconv.Atoi(c.Params("post_number"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid post number",
+		})
+	}
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can restore their posts",
+		})
+	}
+
+	// The owner of the post must not be deleted
+	if _, err := GetUserByUsername(username); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
+		})
+	}
+
+	// Find the deleted post
+	var post models.Post
+	filter := bson.M{"username": username, "post_number": postNumber, "deleted_at": bson.M{"$exists": true}}
+	if err := postsCollection.FindOne(c.Context(), filter).Decode(&post); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Post not found in trash",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve post",
+		})
+	}
+	if !inRetention(post.DeletedAt) {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "Post can no longer be restored",
+		})
+	}
+
+	// Restore the post
+	if err := restorePosts(c.Context(), bson.M{"_id": post.ID}, *post.DeletedAt); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not restore post",
+		})
+	}
+
+	post.DeletedAt = nil
+	return c.JSON(post)
+}
+
+// RestoreComment restores a deleted comment, with the replies deleted with it, within the retention window
+func RestoreComment(c *fiber.Ctx) error {
+	// Get a handle to the comments collection
+	commentsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("comments")
+
+	// Get the comment ID from the request parameters
+	commentID, err := primitive.ObjectIDFromHex(c.Params("comment_id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid comment ID",
+		})
+	}
+
+	// Find the deleted comment
+	var comment models.Comment
+	filter := bson.M{"_id": commentID, "deleted_at": bson.M{"$exists": true}}
+	if err := commentsCollection.FindOne(c.Context(), filter).Decode(&comment); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Comment not found in trash",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve comment",
+		})
+	}
+	if currentUsername(c) != comment.Username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Only the author can restore their comments",
+		})
+	}
+	if !inRetention(comment.DeletedAt) {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"error": "Comment can no longer be restored",
+		})
+	}
+
+	// The post and parent comment must not be deleted
+	restorable, err := commentRestorable(c.Context(), &comment)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve comment",
+		})
+	}
+	if !restorable {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "The post or parent comment must be restored first",
+		})
+	}
+
+	// Restore the comment
+	if err := restoreComment(c.Context(), &comment); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not restore comment",
+		})
+	}
+
+	comment.DeletedAt = nil
+	return c.JSON(comment)
+}
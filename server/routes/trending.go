@@ -0,0 +1,228 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// Engagement events are weighted by how much interest they show in a post. Withdrawn engagement,
+// such as an unlike, is recorded with the negated weight.
+const (
+	likeEngagement    = 1.0
+	commentEngagement = 2.0
+	shareEngagement   = 3.0
+)
+
+// trendingSize is the number of posts and hashtags kept in each trending ranking
+const trendingSize = 100
+
+// trendingRefreshInterval is how often the trending rankings are rebuilt, configured through TRENDING_REFRESH_INTERVAL
+var trendingRefreshInterval = durationFromEnv("TRENDING_REFRESH_INTERVAL", time.Minute)
+
+// trendingWindow is a period over which engagement is ranked.
+// Engagement is counted in buckets, each bucket's count decaying by half every halfLife as it ages.
+type trendingWindow struct {
+	name     string
+	length   time.Duration
+	bucket   time.Duration
+	halfLife time.Duration
+}
+
+// trendingWindows are the periods trending posts and hashtags can be ranked over
+var trendingWindows = []trendingWindow{
+	{name: "hour", length: time.Hour, bucket: 5 * time.Minute, halfLife: 15 * time.Minute},
+	{name: "day", length: 24 * time.Hour, bucket: time.Hour, halfLife: 6 * time.Hour},
+}
+
+// bucketKey is the Redis sorted set counting the engagement of posts or hashtags during the bucket at index
+func (w trendingWindow) bucketKey(kind string, index int64) string {
+	return fmt.Sprintf("trending:%s:%s:%d", kind, w.name, index)
+}
+
+// rankingKey is the Redis sorted set ranking posts or hashtags over the window
+func (w trendingWindow) rankingKey(kind string) string {
+	return fmt.Sprintf("trending:%s:%s", kind, w.name)
+}
+
+// recordEngagement counts an engagement event on a post and its hashtags in the current bucket of each window.
+// Only the hashtags of public posts are counted, as the hashtag rankings are shown to everyone.
+// Failures are only logged, as trending rankings are best effort.
+func recordEngagement(ctx context.Context, post *models.Post, weight float64) {
+	postID := post.ID
+	var hashtags []string
+	if post.Visibility == models.PublicVisibility {
+		hashtags = post.Hashtags
+	}
+
+	now := time.Now()
+	pipe := rdb.TxPipeline()
+	for _, w := range trendingWindows {
+		index := now.UnixNano() / int64(w.bucket)
+
+		postsKey := w.bucketKey("posts", index)
+		pipe.ZIncrBy(ctx, postsKey, weight, postID.Hex())
+		pipe.Expire(ctx, postsKey, w.length+w.bucket)
+
+		if len(hashtags) > 0 {
+			hashtagsKey := w.bucketKey("hashtags", index)
+			for _, tag := range hashtags {
+				pipe.ZIncrBy(ctx, hashtagsKey, weight, tag)
+			}
+			pipe.Expire(ctx, hashtagsKey, w.length+w.bucket)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Could not record engagement on post %s: %v", postID.Hex(), err)
+	}
+}
+
+// refreshTrending rebuilds the trending rankings of each window from its buckets, weighting older buckets less
+func refreshTrending(ctx context.Context) error {
+	// Only one web server refreshes per interval
+	acquired, err := acquireLock(ctx, "trending", trendingRefreshInterval/2)
+	if err != nil || !acquired {
+		return err
+	}
+
+	now := time.Now()
+	for _, w := range trendingWindows {
+		current := now.UnixNano() / int64(w.bucket)
+		buckets := int64(w.length / w.bucket)
+
+		for _, kind := range []string{"posts", "hashtags"} {
+			store := &redis.ZStore{}
+			for age := int64(0); age < buckets; age++ {
+				store.Keys = append(store.Keys, w.bucketKey(kind, current-age))
+				store.Weights = append(store.Weights, math.Pow(0.5, float64(time.Duration(age)*w.bucket)/float64(w.halfLife)))
+			}
+
+			key := w.rankingKey(kind)
+			if err := rdb.ZUnionStore(ctx, key, store).Err(); err != nil {
+				return err
+			}
+			if err := rdb.ZRemRangeByRank(ctx, key, 0, -trendingSize-1).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StartTrendingAggregator periodically rebuilds the trending rankings in the background
+func StartTrendingAggregator() {
+	go func() {
+		ticker := time.NewTicker(trendingRefreshInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := refreshTrending(context.Background()); err != nil {
+				log.Printf("Could not refresh trending rankings: %v", err)
+			}
+		}
+	}()
+}
+
+// GetTrending retrieves the posts and hashtags with the most recent engagement.
+// The window query parameter selects the last "hour" (the default) or "day", and limit the number of each returned.
+func GetTrending(c *fiber.Ctx) error {
+	// Read the window and limit
+	name := c.Query("window", "hour")
+	var window *trendingWindow
+	for i := range trendingWindows {
+		if trendingWindows[i].name == name {
+			window = &trendingWindows[i]
+		}
+	}
+	if window == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid trending window",
+		})
+	}
+	limit := c.QueryInt("limit", 10)
+	if limit < 1 || limit > trendingSize {
+		limit = 10
+	}
+
+	// Read the rankings from Redis
+	rankedPosts, err := rdb.ZRevRangeWithScores(c.Context(), window.rankingKey("posts"), 0, trendingSize-1).Result()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve trending posts from Redis",
+		})
+	}
+	rankedHashtags, err := rdb.ZRevRangeWithScores(c.Context(), window.rankingKey("hashtags"), 0, int64(limit)-1).Result()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve trending hashtags from Redis",
+		})
+	}
+
+	// Load the ranked posts, skipping those that were deleted since or that the requesting user may not see
+	scores := map[primitive.ObjectID]float64{}
+	ids := make([]primitive.ObjectID, 0, len(rankedPosts))
+	for _, ranked := range rankedPosts {
+		id, err := primitive.ObjectIDFromHex(ranked.Member.(string))
+		if err != nil {
+			continue
+		}
+		scores[id] = ranked.Score
+		ids = append(ids, id)
+	}
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+	filter, err := visiblePosts(c.Context(), currentUsername(c), notDeleted(bson.M{"_id": bson.M{"$in": ids}}))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user friends",
+		})
+	}
+	cursor, err := postsCollection.Find(c.Context(), filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+	var posts []models.Post
+	if err := cursor.All(c.Context(), &posts); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve posts from database",
+		})
+	}
+	found := map[primitive.ObjectID]models.Post{}
+	for _, post := range posts {
+		found[post.ID] = post
+	}
+
+	// Keep the ranking order
+	trendingPosts := []models.TrendingPost{}
+	for _, id := range ids {
+		post, ok := found[id]
+		if !ok {
+			continue
+		}
+		trendingPosts = append(trendingPosts, models.TrendingPost{Post: post, Score: scores[id]})
+		if len(trendingPosts) == limit {
+			break
+		}
+	}
+	trendingHashtags := []models.TrendingHashtag{}
+	for _, ranked := range rankedHashtags {
+		trendingHashtags = append(trendingHashtags, models.TrendingHashtag{Tag: ranked.Member.(string), Score: ranked.Score})
+	}
+
+	return c.JSON(fiber.Map{
+		"window":   window.name,
+		"posts":    trendingPosts,
+		"hashtags": trendingHashtags,
+	})
+}
@@ -3,7 +3,6 @@ package routes
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -11,6 +10,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/alexander-winters/SENG468-A2/mymongo"
 	"github.com/alexander-winters/SENG468-A2/mymongo/models"
@@ -38,6 +38,12 @@ func CreateUser(c *fiber.Ctx) error {
 			"error": "Could not parse request body",
 		})
 	}
+	if err := validateProfile(&user); err != nil {
+		return profileError(c, err)
+	}
+
+	// Avatars are only set by uploading one
+	user.Avatar = nil
 
 	// Set the created time
 	now := time.Now()
@@ -92,7 +98,7 @@ func CreateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(user)
+	return c.JSON(user.OwnerProfile())
 }
 
 // GetUserByUsername retrieves a user by username, first checking Redis cache, then the database
@@ -107,7 +113,7 @@ func GetUserByUsername(username string) (*models.User, error) {
 	if err == redis.Nil {
 		// User not found in Redis cache, query the database
 		var user models.User
-		err = usersCollection.FindOne(context.Background(), bson.M{"username": username}).Decode(&user)
+		err = usersCollection.FindOne(context.Background(), notDeleted(bson.M{"username": username})).Decode(&user)
 		if err != nil {
 			return nil, err
 		}
@@ -134,38 +140,40 @@ func GetUserByUsername(username string) (*models.User, error) {
 		if err != nil {
 			return nil, err
 		}
+		if user.DeletedAt != nil {
+			return nil, mongo.ErrNoDocuments
+		}
 		return &user, nil
 	}
 }
 
-// GetUser retrieves a user from the database by username
+// GetUser retrieves a user by username.
+// Users requesting themselves get their full profile, everyone else the public profile.
 func GetUser(c *fiber.Ctx) error {
-	// Get the username from the request parameters
-	username := c.Params("username")
-
 	// Retrieve the user
-	user, err := GetUserByUsername(username)
+	user, err := findProfileUser(c)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"error": "User not found",
-			})
-		}
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not retrieve user",
-		})
+		return profileError(c, err)
 	}
 
-	return c.JSON(user)
+	if currentUsername(c) == user.Username {
+		return c.JSON(user.OwnerProfile())
+	}
+	return c.JSON(user.PublicProfile())
 }
 
-// UpdateUser updates a user in the database and Redis cache by username
+// UpdateUser updates the profile fields given in the request body of the requesting user, in the database and Redis cache
 func UpdateUser(c *fiber.Ctx) error {
 	// Get a handle to the users collection
 	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
 
-	// Get the username from the URL params
+	// Get the username from the URL params, users can only update their own profile
 	username := c.Params("username")
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only update their own profile",
+		})
+	}
 
 	// Parse the request body into a struct
 	var user models.User
@@ -174,131 +182,110 @@ func UpdateUser(c *fiber.Ctx) error {
 			"error": "Could not parse request body",
 		})
 	}
+	if err := validateProfile(&user); err != nil {
+		return profileError(c, err)
+	}
 
-	// Set the updated time
-	user.UpdatedAt = time.Now()
-
-	// Check Redis cache
-	ctx := context.Background()
-	userJSON, err := rdb.Get(ctx, username).Result()
-	if err != nil && err != redis.Nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not get user from Redis",
+	// Posts, comments, friend lists and cache keys all refer to users by username, so it cannot be changed
+	if user.Username != "" && user.Username != username {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Username cannot be changed",
 		})
 	}
 
-	if err == redis.Nil { // User not in Redis cache, update in the database
-		filter := bson.M{"username": username}
-		update := bson.M{"$set": user}
-		_, err := usersCollection.UpdateOne(context.Background(), filter, update)
-		if err != nil {
+	// Users cannot befriend someone they have blocked or who has blocked them
+	for _, friend := range user.ListOfFriends {
+		blockedFriend, err := hasBlocked(username, friend)
+		if err != nil && err != mongo.ErrNoDocuments {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Could not update user in database",
+				"error": "Could not retrieve user",
 			})
 		}
-	} else { // User found in Redis cache, update and store it back
-		err = json.Unmarshal([]byte(userJSON), &user)
-		if err != nil {
+		blockedByFriend, err := hasBlocked(friend, username)
+		if err != nil && err != mongo.ErrNoDocuments {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Could not deserialize user object",
+				"error": "Could not retrieve user",
 			})
 		}
-
-		// Update the user object
-		user.UpdatedAt = time.Now()
-
-		// Serialize the updated user object and store it back in Redis
-		userJSONBytes, err := json.Marshal(user)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Could not serialize user object",
+		if blockedFriend || blockedByFriend {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Cannot add a blocked user as a friend",
 			})
 		}
-		userJSON = string(userJSONBytes)
-		err = rdb.Set(ctx, user.Username, userJSON, 0).Err()
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Could not store user in Redis",
+	}
+
+	// Only the fields given are changed, those maintained by the server, such as the post count and avatar, are left as they are
+	fields, err := profileUpdate(&user, c.Body())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Could not parse request body",
+		})
+	}
+	fields["updated_at"] = time.Now()
+
+	// Update the user in the database
+	filter := notDeleted(bson.M{"username": username})
+	update := bson.M{"$set": fields}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var updatedUser models.User
+	if err := usersCollection.FindOneAndUpdate(c.Context(), filter, update, opts).Decode(&updatedUser); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
 			})
 		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not update user in database",
+		})
+	}
+
+	// Remove the stale user from Redis cache
+	if err := rdb.Del(c.Context(), "user:"+username, username).Err(); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not remove user from Redis",
+		})
 	}
 
 	// Return the updated user
-	return c.JSON(user)
+	return c.JSON(updatedUser.OwnerProfile())
 }
 
-// DeleteUser deletes a user from the database by username
+// DeleteUser moves a user, with their posts and comments, to the trash by username
 func DeleteUser(c *fiber.Ctx) error {
-	// Get a handle to the users collection
-	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
-
-	// Get the username from the URL parameters
+	// Get the username from the URL parameters, users can only delete their own account
 	username := c.Params("username")
-
-	// Check Redis cache
-	ctx := context.Background()
-	_, err := rdb.Get(ctx, username).Result()
-	if err != redis.Nil && err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Could not get user from Redis",
+	if currentUsername(c) != username {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Users can only delete their own account",
 		})
 	}
 
-	if err != redis.Nil { // User found in Redis cache, delete it
-		err = rdb.Del(ctx, username).Err()
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Could not delete user from Redis",
-			})
-		}
-	}
-
-	// Use a Goroutine to delete the user from the database
-	errChan := make(chan error)
-	go func() {
-		// Delete the user from the database
-		res, err := usersCollection.DeleteOne(context.Background(), bson.M{"username": username})
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		// Check if a document was deleted
-		if res.DeletedCount == 0 {
-			errChan <- fmt.Errorf("user not found")
-			return
-		}
-
-		errChan <- nil
-	}()
-
-	// Wait for the Goroutine to complete or timeout
-	select {
-	case err := <-errChan:
-		if err != nil {
-			if err.Error() == "User not found" {
-				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-					"error": "User not found",
-				})
-			}
-
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Could not delete user from database",
+	// Retrieve the user
+	user, err := GetUserByUsername(username)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
 			})
 		}
-
-		return c.JSON(fiber.Map{
-			"message": "User deleted successfully",
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Could not retrieve user",
 		})
+	}
 
-	case <-time.After(5 * time.Second):
+	// Mark the user, their posts and their comments as deleted and remove them from Redis cache
+	if err := trashUser(c.Context(), user, time.Now()); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Timed out while deleting user from database",
+			"error": "Could not delete user from database",
 		})
 	}
+
+	return c.JSON(fiber.Map{
+		"message": "User moved to trash",
+	})
 }
 
-// ListUsers retrieves all users from the database
+// ListUsers retrieves the public profiles of all users
 func ListUsers(c *fiber.Ctx) error {
 	ctx := context.Background()
 
@@ -334,12 +321,14 @@ func ListUsers(c *fiber.Ctx) error {
 				})
 			}
 
-			redisUsers[user.Username] = user
+			if user.DeletedAt == nil {
+				redisUsers[user.Username] = user
+			}
 		}
 	}
 
 	// Find all users in the database
-	cursor, err := usersCollection.Find(context.Background(), bson.M{})
+	cursor, err := usersCollection.Find(context.Background(), notDeleted(bson.M{}))
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Could not retrieve users from database",
@@ -357,14 +346,16 @@ func ListUsers(c *fiber.Ctx) error {
 	// Merge users from Redis and the database
 	for _, user := range dbUsers {
 		if _, exists := redisUsers[user.Username]; !exists {
-			redisUsers[user.Username] = user
+			if user.DeletedAt == nil {
+				redisUsers[user.Username] = user
+			}
 		}
 	}
 
-	// Convert the user map into a slice
-	users := make([]models.User, 0, len(redisUsers))
+	// Convert the user map into a slice of public profiles
+	users := make([]models.PublicProfile, 0, len(redisUsers))
 	for _, user := range redisUsers {
-		users = append(users, user)
+		users = append(users, user.PublicProfile())
 	}
 
 	// Return the users
@@ -374,7 +365,7 @@ func ListUsers(c *fiber.Ctx) error {
 func GetUserFriends(username string) ([]string, error) {
 	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
 
-	filter := bson.M{"username": username}
+	filter := notDeleted(bson.M{"username": username})
 	var user models.User
 	err := usersCollection.FindOne(context.Background(), filter).Decode(&user)
 	if err != nil {
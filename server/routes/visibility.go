@@ -0,0 +1,143 @@
+package routes
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alexander-winters/SENG468-A2/mymongo"
+	"github.com/alexander-winters/SENG468-A2/mymongo/models"
+)
+
+// isVisibility reports whether visibility is one of the supported visibility levels
+func isVisibility(visibility models.Visibility) bool {
+	switch visibility {
+	case models.PublicVisibility, models.FriendsVisibility, models.PrivateVisibility:
+		return true
+	}
+	return false
+}
+
+// canViewPost reports whether viewer may see a post.
+// Public posts are visible to everyone, friends-only posts to the author and their friends, and private posts to the author only.
+// The posts of private groups are visible to the group's members.
+// Posts are never visible to users the author has blocked.
+func canViewPost(post *models.Post, viewer string) (bool, error) {
+	if viewer != "" && viewer != post.Username {
+		blocked, err := hasBlocked(post.Username, viewer)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return false, err
+		}
+		if blocked {
+			return false, nil
+		}
+	}
+
+	switch post.Visibility {
+	case models.FriendsVisibility:
+		if viewer == "" {
+			return false, nil
+		}
+		if viewer == post.Username {
+			return true, nil
+		}
+		friends, err := GetUserFriends(post.Username)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return false, nil
+			}
+			return false, err
+		}
+		for _, friend := range friends {
+			if friend == viewer {
+				return true, nil
+			}
+		}
+		return false, nil
+	case models.PrivateVisibility:
+		return viewer != "" && viewer == post.Username, nil
+	case models.GroupVisibility:
+		if viewer == "" {
+			return false, nil
+		}
+		if viewer == post.Username {
+			return true, nil
+		}
+		return isGroupMember(context.Background(), post.GroupID, viewer)
+	}
+	return true, nil
+}
+
+// friendsOf returns the users who have viewer in their friend list, whose friends-only posts viewer may see
+func friendsOf(ctx context.Context, viewer string) ([]string, error) {
+	if viewer == "" {
+		return nil, nil
+	}
+	usersCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("users")
+
+	filter := notDeleted(bson.M{"list_of_friends": viewer})
+	cursor, err := usersCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"username": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	usernames := make([]string, 0, len(users))
+	for _, user := range users {
+		usernames = append(usernames, user.Username)
+	}
+	return usernames, nil
+}
+
+// visiblePosts adds a condition to a posts filter that keeps only the posts viewer may see
+func visiblePosts(ctx context.Context, viewer string, filter bson.M) (bson.M, error) {
+	authors, err := friendsOf(ctx, viewer)
+	if err != nil {
+		return nil, err
+	}
+	blockers, err := blockedBy(ctx, viewer)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := groupsOf(ctx, viewer)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{"$and": bson.A{filter, mymongo.VisiblePostsFilter(viewer, authors, blockers, groups, "")}}, nil
+}
+
+// findVisiblePost retrieves a post by username and post number if the requesting user may see it.
+// Posts hidden from the requesting user are reported as not found.
+func findVisiblePost(c *fiber.Ctx, username string, postNumber int) (*models.Post, error) {
+	post, err := GetPostByUsername(username, postNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	visible, err := canViewPost(post, currentUsername(c))
+	if err != nil {
+		return nil, err
+	}
+	if !visible {
+		return nil, mongo.ErrNoDocuments
+	}
+	return post, nil
+}
+
+// findPostByID retrieves a live post by its ID from the database
+func findPostByID(ctx context.Context, id primitive.ObjectID) (*models.Post, error) {
+	postsCollection := mymongo.GetMongoClient().Database("seng468-a2-db").Collection("posts")
+
+	var post models.Post
+	if err := postsCollection.FindOne(ctx, notDeleted(bson.M{"_id": id})).Decode(&post); err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/alexander-winters/SENG468-A2/mymongo"
 	"github.com/alexander-winters/SENG468-A2/server/routes"
 )
 
@@ -13,8 +15,23 @@ import (
 // var client = mymongo.GetMongoClient()
 
 func main() {
-	// Initialize a new Fiber app
-	app := fiber.New()
+	// Create the database indexes
+	if err := mymongo.CreateIndexes(context.Background()); err != nil {
+		log.Fatalf("Could not create database indexes: %v", err)
+	}
+
+	// Create the bucket attachments are stored in, if they are kept in an object store
+	if err := routes.PrepareMediaStore(context.Background()); err != nil {
+		log.Fatalf("Could not prepare media store: %v", err)
+	}
+
+	// Initialize a new Fiber app, accepting request bodies large enough for file uploads
+	app := fiber.New(fiber.Config{
+		BodyLimit: routes.UploadBodyLimit,
+	})
+
+	// Identify the requesting user on every route
+	app.Use(routes.SetCurrentUser)
 
 	// Set up a simple route
 	app.Get("/", func(c *fiber.Ctx) error {
@@ -24,32 +41,167 @@ func main() {
 	// Set up the routes for users
 	app.Post("/user", routes.CreateUser)
 	app.Get("/user/:username", routes.GetUser)
+	app.Get("/user/:username/profile", routes.GetProfile)
+	app.Put("/user/:username/avatar", routes.UploadAvatar)
+	app.Get("/user/:username/avatar", routes.GetAvatar)
+	app.Delete("/user/:username/avatar", routes.DeleteAvatar)
 	app.Put("/user/:username", routes.UpdateUser)
 	app.Delete("/user/:username", routes.DeleteUser)
 	app.Get("/users", routes.ListUsers)
+	app.Post("/user/:username/restore", routes.RestoreUser)
+	app.Get("/user/:username/trash", routes.ListTrash)
+	app.Get("/user/:username/suggestions", routes.ListFriendSuggestions)
+	app.Get("/user/:username/blocks", routes.ListBlockedUsers)
+	app.Put("/user/:username/blocks/:blocked_username", routes.BlockUser)
+	app.Delete("/user/:username/blocks/:blocked_username", routes.UnblockUser)
+	app.Post("/user/:username/account-deletion", routes.StartAccountDeletion)
+	app.Post("/user/:username/export", routes.StartDataExport)
+	app.Get("/jobs/:job_id", routes.GetJob)
+	app.Get("/jobs/:job_id/download", routes.DownloadDataExport)
 
 	// Set up the routes for posts
 	app.Post("/user/:username/post", routes.CreatePost)
 	app.Get("/user/:username/post/:post_number", routes.GetPost)
 	app.Put("/user/:username/post/:post_number", routes.UpdatePost)
 	app.Delete("/user/:username/post/:post_number", routes.DeletePost)
+	app.Post("/user/:username/post/:post_number/restore", routes.RestorePost)
+	app.Get("/user/:username/post/:post_number/revisions", routes.ListPostRevisions)
+	app.Get("/user/:username/post/:post_number/revisions/:revision_number", routes.GetPostRevision)
+	app.Post("/user/:username/post/:post_number/attachments", routes.UploadAttachments)
+	app.Get("/user/:username/post/:post_number/attachments/:attachment_id", routes.DownloadAttachment)
+	app.Delete("/user/:username/post/:post_number/attachments/:attachment_id", routes.DeleteAttachment)
+	app.Post("/user/:username/post/:post_number/share", routes.SharePost)
+	app.Delete("/user/:username/post/:post_number/share", routes.UnsharePost)
+	app.Get("/user/:username/post/:post_number/shares", routes.ListPostShares)
+	app.Get("/user/:username/feed", routes.GetFeed)
+	app.Put("/user/:username/post/:post_number/bookmark", routes.BookmarkPost)
+	app.Delete("/user/:username/post/:post_number/bookmark", routes.UnbookmarkPost)
+	app.Get("/user/:username/bookmarks", routes.ListBookmarks)
+	app.Post("/user/:username/bookmark-collections", routes.CreateBookmarkCollection)
+	app.Get("/user/:username/bookmark-collections", routes.ListBookmarkCollections)
+	app.Put("/user/:username/bookmark-collections/:collection", routes.RenameBookmarkCollection)
+	app.Delete("/user/:username/bookmark-collections/:collection", routes.DeleteBookmarkCollection)
+	app.Get("/user/:username/post/:post_number/poll", routes.GetPollResults)
+	app.Put("/user/:username/post/:post_number/poll/vote", routes.VotePoll)
+	app.Post("/user/:username/post/:post_number/poll/close", routes.ClosePoll)
+	app.Put("/user/:username/post/:post_number/pin", routes.PinPost)
+	app.Delete("/user/:username/post/:post_number/pin", routes.UnpinPost)
+	app.Get("/user/:username/pins", routes.ListPinnedPosts)
+	app.Put("/user/:username/pins", routes.ReorderPins)
 	app.Get("/users/:username/posts", routes.ListUserPosts)
 	app.Get("/posts", routes.ListAllPosts)
 	app.Put("/user/:username/post/:post_number/like", routes.LikePost)
+	app.Delete("/user/:username/post/:post_number/like", routes.UnlikePost)
+	app.Put("/user/:username/post/:post_number/reaction", routes.ReactToPost)
+	app.Delete("/user/:username/post/:post_number/reaction", routes.RemovePostReaction)
+	app.Get("/user/:username/post/:post_number/reactions", routes.ListPostReactions)
+
+	// Set up the routes for drafts and scheduled posts
+	app.Post("/user/:username/drafts", routes.CreateDraft)
+	app.Get("/user/:username/drafts", routes.ListDrafts)
+	app.Get("/user/:username/drafts/:draft_id", routes.GetDraft)
+	app.Put("/user/:username/drafts/:draft_id", routes.UpdateDraft)
+	app.Delete("/user/:username/drafts/:draft_id", routes.DeleteDraft)
+	app.Post("/user/:username/drafts/:draft_id/publish", routes.PublishDraft)
 
 	// Set up the routes for comments
-	app.Post("user/:username/post/:post_number/comment", routes.CreateComment)
-	app.Get("/user/:username/post/:post_number/comment", routes.GetComment)
-	app.Put("user/:username/post/:post_number/comment", routes.UpdateComment)
-	app.Delete("user/:username/post/:post_number/comment", routes.DeleteComment)
-	app.Get("/post/:post_number/comments", routes.ListComments)
-	app.Put("/user/:username/post/:post_number/comment/like", routes.LikeComment)
+	app.Post("/user/:username/post/:post_number/comment", routes.CreateComment)
+	app.Get("/user/:username/post/:post_number/comments", routes.ListComments)
+	app.Get("/user/:username/post/:post_number/comment/:comment_id", routes.GetComment)
+	app.Put("/user/:username/post/:post_number/comment/:comment_id", routes.UpdateComment)
+	app.Delete("/user/:username/post/:post_number/comment/:comment_id", routes.DeleteComment)
+	app.Put("/user/:username/post/:post_number/comment/:comment_id/like", routes.LikeComment)
+	app.Delete("/user/:username/post/:post_number/comment/:comment_id/like", routes.UnlikeComment)
+	app.Put("/user/:username/post/:post_number/comment/:comment_id/reaction", routes.ReactToComment)
+	app.Delete("/user/:username/post/:post_number/comment/:comment_id/reaction", routes.RemoveCommentReaction)
+	app.Get("/user/:username/post/:post_number/comment/:comment_id/reactions", routes.ListCommentReactions)
+	app.Post("/user/:username/post/:post_number/comment/:comment_id/replies", routes.CreateReply)
+	app.Get("/user/:username/post/:post_number/comment/:comment_id/replies", routes.GetReplies)
+	app.Get("/user/:username/post/:post_number/comment/:comment_id/revisions", routes.ListCommentRevisions)
+	app.Get("/user/:username/post/:post_number/comment/:comment_id/revisions/:revision_number", routes.GetCommentRevision)
+	app.Get("/comments/:comment_id", routes.GetComment)
+	app.Put("/comments/:comment_id", routes.UpdateComment)
+	app.Delete("/comments/:comment_id", routes.DeleteComment)
+	app.Post("/comments/:comment_id/restore", routes.RestoreComment)
+	app.Put("/comments/:comment_id/like", routes.LikeComment)
+	app.Delete("/comments/:comment_id/like", routes.UnlikeComment)
+	app.Put("/comments/:comment_id/reaction", routes.ReactToComment)
+	app.Delete("/comments/:comment_id/reaction", routes.RemoveCommentReaction)
+	app.Get("/comments/:comment_id/reactions", routes.ListCommentReactions)
+	app.Post("/comments/:comment_id/replies", routes.CreateReply)
+	app.Get("/comments/:comment_id/replies", routes.GetReplies)
+	app.Get("/comments/:comment_id/revisions", routes.ListCommentRevisions)
+	app.Get("/comments/:comment_id/revisions/:revision_number", routes.GetCommentRevision)
+
+	// Set up the routes for stories
+	app.Post("/user/:username/stories", routes.CreateStory)
+	app.Get("/user/:username/stories", routes.ListUserStories)
+	app.Get("/user/:username/stories/feed", routes.GetStoryFeed)
+	app.Get("/stories/:story_id", routes.GetStory)
+	app.Get("/stories/:story_id/viewers", routes.ListStoryViewers)
+	app.Delete("/stories/:story_id", routes.DeleteStory)
+
+	// Set up the routes for groups
+	app.Post("/groups", routes.CreateGroup)
+	app.Get("/groups", routes.ListGroups)
+	app.Get("/groups/:group_id", routes.GetGroup)
+	app.Put("/groups/:group_id", routes.UpdateGroup)
+	app.Delete("/groups/:group_id", routes.DeleteGroup)
+	app.Post("/groups/:group_id/join", routes.JoinGroup)
+	app.Post("/groups/:group_id/leave", routes.LeaveGroup)
+	app.Post("/groups/:group_id/invites", routes.InviteToGroup)
+	app.Get("/groups/:group_id/members", routes.ListGroupMembers)
+	app.Post("/groups/:group_id/members/:member/approve", routes.ApproveGroupMember)
+	app.Put("/groups/:group_id/members/:member/role", routes.SetGroupMemberRole)
+	app.Delete("/groups/:group_id/members/:member", routes.RemoveGroupMember)
+	app.Post("/groups/:group_id/posts", routes.CreateGroupPost)
+	app.Get("/groups/:group_id/feed", routes.GetGroupFeed)
+
+	// Set up the routes for direct messages
+	app.Post("/user/:username/conversations", routes.CreateConversation)
+	app.Get("/user/:username/conversations", routes.ListConversations)
+	app.Get("/conversations/:conversation_id", routes.GetConversation)
+	app.Put("/conversations/:conversation_id/read", routes.MarkConversationRead)
+	app.Post("/conversations/:conversation_id/messages", routes.SendMessage)
+	app.Get("/conversations/:conversation_id/messages", routes.ListMessages)
+	app.Put("/conversations/:conversation_id/messages/:message_id", routes.EditMessage)
+	app.Delete("/conversations/:conversation_id/messages/:message_id", routes.DeleteMessage)
+
+	// Set up the routes for trending posts and hashtags
+	app.Get("/trending", routes.GetTrending)
+
+	// Set up the routes for search
+	app.Get("/search", routes.Search)
+
+	// Set up the routes for hashtags
+	app.Get("/tags/:tag", routes.ListTaggedPosts)
+
+	// Set up the routes for reactions
+	app.Get("/reactions", routes.ListReactionKinds)
 
 	// Set up the routes for reports
 	app.Get("/reports/:username/posts", routes.PostReport)
 	app.Get("/reports/:username/comments", routes.UserCommentReport)
 	app.Get("/reports/:username/likes", routes.LikeReport)
 
+	// Purge expired items from the trash in the background
+	routes.StartTrashPurge()
+
+	// Remove expired data exports in the background
+	routes.StartExportCleanup()
+
+	// Rebuild the trending rankings in the background
+	routes.StartTrendingAggregator()
+
+	// Publish scheduled posts when they are due in the background
+	routes.StartPostScheduler()
+
+	// Close polls when they are due in the background
+	routes.StartPollCloser()
+
+	// Recompute friend suggestions in the background
+	routes.StartSuggestionRefresher()
+
 	// Start the server on the specified port
 	port := os.Getenv("PORT")
 	if port == "" {